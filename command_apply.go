@@ -1,6 +1,12 @@
 package main
 
-import "github.com/ceymard/dmut/v2/mutations"
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
 
 type ApplyCmd struct {
 	Uri      string   `arg:"" help:"Database host."`
@@ -8,16 +14,61 @@ type ApplyCmd struct {
 	Override bool     `short:"o" name:"override" help:"Save the mutations to the database, but don't run them."`
 	Verbose  bool     `short:"v" help:"Verbose output."`
 	Dry      bool     `short:"d" help:"Dry run, don't apply the mutations."`
+	Json     bool     `short:"j" help:"Output the plan of what was run as json on stdout, logging on stderr."`
+	Phased   bool     `name:"phased" help:"Commit every step in its own transaction, recording the progress so that an interrupted apply can be resumed."`
+	Resume   bool     `name:"resume" help:"Resume an interrupted phased apply."`
+
+	LockTimeout      time.Duration `name:"lock-timeout" help:"How long to wait for another dmut applying to the same database, and for the locks taken by the statements. Forever by default."`
+	StatementTimeout time.Duration `name:"statement-timeout" help:"How long a statement may run, unless its mutation has a timeout. The server's statement_timeout by default."`
+
+	AllowSqlDown []string `name:"allow-sql-down" help:"Glob patterns of the mutations whose sql may be downed. Any sql down is refused otherwise."`
+
+	Namespaces []string `short:"n" name:"namespace" help:"Only load and apply these namespaces."`
+
+	Only    []string `name:"only" help:"Only apply the mutations matching these selectors, and the parents they need. Selectors are mutation name globs, namespace:<glob> or file:<path>."`
+	Exclude []string `name:"exclude" help:"Leave the mutations matching these selectors as they are in the database."`
+
+	VarsFlags
 }
 
-func (a ApplyCmd) Run() error {
+func (a ApplyCmd) Run(ctx context.Context) error {
+	vars, err := a.Vars()
+	if err != nil {
+		return err
+	}
+
+	var plans []*mutations.MutationPlan
+	var on_plan func(*mutations.MutationPlan)
+	if a.Json {
+		mutations.LogOutput = os.Stderr
+		on_plan = func(plan *mutations.MutationPlan) {
+			plans = append(plans, plan)
+		}
+	}
+
+	if err := mutations.ReadAndRunMutations(ctx, a.Uri, a.Paths, mutations.MutationRunnerOptions{
+		Verbose:    a.Verbose,
+		Commit:     !a.Dry,
+		Override:   a.Override,
+		OnPlan:     on_plan,
+		Vars:       vars,
+		Namespaces: a.Namespaces,
+		Only:       a.Only,
+		Exclude:    a.Exclude,
+		Phased:     a.Phased,
+		Resume:     a.Resume,
 
-	if err := mutations.ReadAndRunMutations(a.Uri, a.Paths, mutations.MutationRunnerOptions{
-		Verbose:  a.Verbose,
-		Commit:   !a.Dry,
-		Override: a.Override,
+		LockTimeout:      a.LockTimeout,
+		StatementTimeout: a.StatementTimeout,
+
+		GuardSqlDown: true,
+		AllowSqlDown: a.AllowSqlDown,
 	}); err != nil {
 		return err
 	}
+
+	if a.Json {
+		return mutations.WritePlansJson(os.Stdout, plans)
+	}
 	return nil
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+// Baseline an existing database by reading its catalog into mutations, which can then be recorded with overwrite.
+
+type BaselineCmd struct {
+	Outfile   string   `short:"o" name:"out" help:"Output yaml file, defaults to stdout."`
+	Namespace string   `short:"n" help:"Namespace of the generated mutations."`
+	Schemas   []string `short:"s" name:"schema" help:"Only read these schemas, defaults to all the non system ones."`
+	Uri       string   `arg:"" help:"Database uri."`
+	Verbose   bool     `short:"v" help:"Verbose output."`
+}
+
+func (b BaselineCmd) Run(ctx context.Context) error {
+	if b.Outfile == "" {
+		mutations.LogOutput = os.Stderr
+	}
+
+	runner, err := mutations.NewPgRunner(ctx, b.Uri, b.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	set, err := runner.Introspect(ctx, b.Namespace, b.Schemas)
+	if err != nil {
+		return err
+	}
+
+	if b.Outfile == "" {
+		return set.WriteYaml(os.Stdout)
+	}
+
+	f, err := os.Create(b.Outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return set.WriteYaml(f)
+}
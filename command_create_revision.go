@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+type CreateRevisionCmd struct {
+	Outfile  string   `short:"o" name:"out" help:"Output yaml file, defaults to stdout."`
+	Previous string   `arg:"" help:"Database uri or previous revision file."`
+	Paths    []string `arg:"" help:"Paths to the mutations of the future revision."`
+
+	VarsFlags
+}
+
+func (c CreateRevisionCmd) Run(ctx context.Context) error {
+	if c.Outfile == "" {
+		mutations.LogOutput = os.Stderr
+	}
+
+	vars, err := c.Vars()
+	if err != nil {
+		return err
+	}
+
+	previous, err := mutations.LoadMutationSource(ctx, c.Previous, vars)
+	if err != nil {
+		return err
+	}
+
+	local, err := mutations.LoadYamlMutationsWithVars(vars, c.Paths...)
+	if err != nil {
+		return err
+	}
+
+	var names = local.Keys()
+	slices.Sort(names)
+
+	var res = mutations.NewMutationNamespace()
+	for _, namespace := range names {
+		if namespace == mutations.DMUT_NAMESPACE {
+			continue
+		}
+		revision, err := mutations.CreateRevision(previous.Latest(namespace), local.Latest(namespace))
+		if err != nil {
+			return err
+		}
+		if err := res.AddSet(revision); err != nil {
+			return err
+		}
+	}
+
+	if c.Outfile == "" {
+		return res.WriteYaml(os.Stdout)
+	}
+
+	f, err := os.Create(c.Outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return res.WriteYaml(f)
+}
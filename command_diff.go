@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+type DiffCmd struct {
+	From string `arg:"" help:"Database uri, directory or yaml file to compare from."`
+	To   string `arg:"" help:"Database uri, directory or yaml file to compare to."`
+
+	VarsFlags
+}
+
+func (c DiffCmd) Run(ctx context.Context) error {
+	mutations.LogOutput = os.Stderr
+
+	vars, err := c.Vars()
+	if err != nil {
+		return err
+	}
+
+	from, err := mutations.LoadMutationSource(ctx, c.From, vars)
+	if err != nil {
+		return err
+	}
+
+	to, err := mutations.LoadMutationSource(ctx, c.To, vars)
+	if err != nil {
+		return err
+	}
+
+	var names = from.Keys()
+	for _, namespace := range to.Keys() {
+		if !slices.Contains(names, namespace) {
+			names = append(names, namespace)
+		}
+	}
+	slices.Sort(names)
+
+	var no_changes = true
+	for _, namespace := range names {
+		if namespace == mutations.DMUT_NAMESPACE {
+			continue
+		}
+
+		to_set := to.Latest(namespace)
+		from_set := from.Latest(namespace)
+		if to_set != nil {
+			// compare the same way apply would
+			from_set = from_set.ForRevision(to_set.Revision)
+		}
+
+		for _, diff := range mutations.DiffSets(from_set, to_set) {
+			no_changes = false
+			diff.Print(os.Stdout)
+		}
+	}
+
+	if no_changes {
+		fmt.Fprintln(os.Stderr, "no differences")
+	}
+
+	return nil
+}
@@ -1,44 +1,82 @@
 package main
 
-import "github.com/ceymard/dmut/v2/mutations"
+import (
+	"context"
+	"time"
+
+	"github.com/ceymard/dmut/v2/mutations"
+	au "github.com/logrusorgru/aurora"
+)
 
 type DownCmd struct {
 	Uri       string `arg:"" help:"Database host."`
 	Verbose   bool   `short:"v" help:"Verbose output."`
 	Namespace string `arg:"" help:"Namespace to down."`
 	Dry       bool   `short:"d" help:"Dry run, don't down the mutations."`
+
+	LockTimeout      time.Duration `name:"lock-timeout" help:"How long to wait for another dmut applying to the same database, and for the locks taken by the statements. Forever by default."`
+	StatementTimeout time.Duration `name:"statement-timeout" help:"How long a statement may run, unless its mutation has a timeout. The server's statement_timeout by default."`
 }
 
-func (c DownCmd) Run() error {
+func (c DownCmd) Run(ctx context.Context) error {
 	var fake_empty_local_set *mutations.MutationSet = mutations.NewMutationSet(c.Namespace, 0, "")
 
-	runner, err := mutations.NewPgRunner(c.Uri, c.Verbose)
+	runner, err := mutations.NewPgRunner(ctx, c.Uri, c.Verbose)
 	if err != nil {
 		return err
 	}
 	defer runner.Close()
 
-	db_mutations, err := runner.GetDBMutationsFromDb(c.Namespace)
+	// the locks outlive the transaction, they are released once it is over
+	defer func() {
+		if err := runner.Unlock(ctx); err != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err)
+		}
+	}()
+
+	for _, namespace := range []string{mutations.DMUT_NAMESPACE, c.Namespace} {
+		if err := runner.Lock(ctx, namespace, c.LockTimeout); err != nil {
+			return err
+		}
+	}
+
+	db_mutations, err := runner.GetDBMutationsFromDb(ctx, c.Namespace)
 	if err != nil {
 		return err
 	}
 
 	fake_empty_local_set.Revision = db_mutations.Revision
 
-	if err := runner.Begin(); err != nil {
+	if err := runner.Begin(ctx); err != nil {
 		return err
 	}
 
-	if err := mutations.RunMutations(runner, fake_empty_local_set, &mutations.MutationRunnerOptions{
-		Verbose: c.Verbose,
-		Commit:  !c.Dry,
-	}); err != nil {
+	if err := runner.SetTimeouts(ctx, c.StatementTimeout, c.LockTimeout); err != nil {
+		return err
+	}
+
+	declared_roles, err := runner.DeclaredRoles(ctx)
+	if err == nil {
+		// there is nothing to run outside of the transaction when downing
+		_, err = mutations.RunMutations(ctx, runner, fake_empty_local_set, &mutations.MutationRunnerOptions{
+			Verbose: c.Verbose,
+			Commit:  !c.Dry,
+		})
+	}
+	if err == nil {
+		// the roles of the namespace are dropped unless another one declares them
+		err = runner.DropRoles(ctx, declared_roles)
+	}
+	if err != nil {
+		if err2 := runner.Rollback(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
 		return err
 	}
 
 	if c.Dry {
-		return runner.Rollback()
+		return runner.Rollback(ctx)
 	}
 
-	return runner.Commit()
+	return runner.Commit(ctx)
 }
@@ -1,59 +1,228 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 
-	"github.com/k0kubun/pp"
+	"github.com/ceymard/dmut/v2/mutations"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/samber/oops"
 )
 
 type ExplodeCmd struct {
 	OutDir string   `required:"" short:"o" name:"out-dir" help:"Output directory to write the mutations to."`
+	Force  bool     `short:"f" help:"Overwrite existing files."`
 	Paths  []string `arg:"" help:"Paths to the mutation files"`
 }
 
-func (e ExplodeCmd) Run() error {
+const yaml_modeline = "# yaml-embedded-languages: sql"
 
-	re_mutation := regexp.MustCompile(`(?m)^([^:\n]+):[ \t]*\n(([ \t][^\n]*)?\n)*`)
+type explodedFile struct {
+	Path     string
+	Contents []byte
+}
 
-	type naive_mutation struct {
-		Name    string
-		Content string
-	}
+func (e ExplodeCmd) Run() error {
+	var files []explodedFile
 
 	for _, pth := range e.Paths {
-		bytes, err := os.ReadFile(pth)
+		source, err := os.ReadFile(pth)
 		if err != nil {
 			return err
 		}
-		matches := re_mutation.FindAllStringSubmatch(string(bytes), -1)
-		pp.Println(matches)
-		for _, match := range matches {
-			name := match[1]
-			contents := match[0]
-
-			components := strings.Split(name, ".")
-			last_name := components[len(components)-1]
-			out_file := path.Join(e.OutDir, last_name+".yml")
-			if len(components) > 1 {
-				dir := path.Join(components[:len(components)-1]...)
-				if err := os.MkdirAll(filepath.Join(e.OutDir, dir), 0755); err != nil {
-					return err
-				}
-				out_file = path.Join(e.OutDir, dir, last_name+".yml")
+
+		exploded, err := explodeYaml(e.OutDir, source)
+		if err != nil {
+			return oops.In("explode").With("file", pth).Wrap(err)
+		}
+		files = append(files, exploded...)
+	}
+
+	return writeExplodedFiles(files, e.Force)
+}
+
+// explodeYaml splits every document of a mutation file into one file per mutation, children included.
+// Every file keeps the __namespace and __revision of its document, as well as the comments.
+func explodeYaml(out_dir string, source []byte) ([]explodedFile, error) {
+	file, err := parser.ParseBytes(source, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []explodedFile
+	for _, doc := range file.Docs {
+		if doc.Body == nil {
+			continue
+		}
+		body, ok := doc.Body.(*ast.MappingNode)
+		if !ok {
+			return nil, oops.Errorf("expected a mapping node, got %T", doc.Body)
+		}
+
+		var header []string
+		var muts []*ast.MappingValueNode
+		for _, value := range body.Values {
+			var key string
+			if err := yaml.NodeToValue(value.Key, &key); err != nil {
+				return nil, oops.Wrapf(err, "error decoding key %T", value.Key)
 			}
+			if key == "__namespace" || key == "__revision" {
+				header = append(header, strings.Trim(value.String(), "\n"))
+			} else {
+				muts = append(muts, value)
+			}
+		}
 
-			if err := os.WriteFile(out_file, []byte(contents), 0644); err != nil {
-				return err
+		for _, value := range muts {
+			var name string
+			if err := yaml.NodeToValue(value.Key, &name); err != nil {
+				return nil, oops.Wrapf(err, "error decoding key %T", value.Key)
 			}
-			fmt.Println(out_file)
+			exploded, err := explodeMutation(out_dir, header, name, value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, exploded...)
+		}
+	}
+	return res, nil
+}
+
+// explodeMutation takes the children out of a mutation node and renames them with their full dotted name,
+// so that they may be written to files of their own.
+func explodeMutation(out_dir string, header []string, name string, node *ast.MappingValueNode) ([]explodedFile, error) {
+	var res []explodedFile
+
+	def, ok := node.Value.(*ast.MappingNode)
+	if !ok {
+		return nil, oops.With("mutation", name).Errorf("expected a map describing a mutation, got %T", node.Value)
+	}
+
+	var children []*ast.MappingValueNode
+	def.Values = slices.DeleteFunc(def.Values, func(value *ast.MappingValueNode) bool {
+		var key string
+		if err := yaml.NodeToValue(value.Key, &key); err != nil || key != "children" {
+			return false
+		}
+		if children_def, ok := value.Value.(*ast.MappingNode); ok {
+			children = append(children, children_def.Values...)
+		}
+		return true
+	})
 
+	for _, child := range children {
+		var child_name string
+		if err := yaml.NodeToValue(child.Key, &child_name); err != nil {
+			return nil, oops.With("mutation", name).Wrapf(err, "error decoding child name %T", child.Key)
+		}
+		key, ok := child.Key.(*ast.StringNode)
+		if !ok {
+			return nil, oops.With("mutation", name).Errorf("expected a string as child name, got %T", child.Key)
+		}
+		key.Value = name + "." + child_name
+		key.Token.Value = key.Value
+
+		exploded, err := explodeMutation(out_dir, header, key.Value, child)
+		if err != nil {
+			return nil, err
 		}
+		res = append(res, exploded...)
 	}
 
+	// a mutation that only held children has nothing left to write
+	if len(def.Values) == 0 {
+		return res, nil
+	}
+
+	// children are indented under their parent, bring them back to the top level
+	var width = node.Key.GetToken().Position.Column - 1
+	node.AddColumn(-width)
+	dedentBlockScalars(node, width)
+
+	var buf bytes.Buffer
+	contents := strings.Trim(node.String(), "\n")
+	if !strings.HasPrefix(contents, yaml_modeline) && (len(header) == 0 || !strings.HasPrefix(header[0], yaml_modeline)) {
+		buf.WriteString(yaml_modeline + "\n\n")
+	}
+	if len(header) > 0 {
+		buf.WriteString(strings.Join(header, "\n") + "\n\n")
+	}
+	buf.WriteString(contents + "\n")
+
+	res = append(res, explodedFile{Path: explodedPath(out_dir, name), Contents: buf.Bytes()})
+	return res, nil
+}
+
+// dedentBlockScalars takes the indentation off the lines of the block scalars, which AddColumn leaves as they were read.
+func dedentBlockScalars(node ast.Node, width int) {
+	if width <= 0 {
+		return
+	}
+	var indent = strings.Repeat(" ", width)
+	for _, lit := range ast.Filter(ast.LiteralType, node) {
+		tk := lit.(*ast.LiteralNode).Value.GetToken()
+		lines := strings.Split(tk.Origin, "\n")
+		for i, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				lines[i] = strings.TrimLeft(line, " ")
+			} else {
+				lines[i] = strings.TrimPrefix(line, indent)
+			}
+		}
+		tk.Origin = strings.Join(lines, "\n")
+	}
+}
+
+// explodedPath is the file of a mutation in an exploded tree, where a.b.c goes to a/b/c.yml
+func explodedPath(out_dir string, name string) string {
+	components := strings.Split(name, ".")
+	components[len(components)-1] += ".yml"
+	return path.Join(append([]string{out_dir}, components...)...)
+}
+
+// writeExplodedFiles refuses to overwrite existing files unless forced, and checks all of them before
+// writing anything, so that a refused explode does not leave half written trees behind.
+func writeExplodedFiles(files []explodedFile, force bool) error {
+	var seen = make(map[string]bool)
+	for _, file := range files {
+		if seen[file.Path] {
+			return oops.In("explode").With("file", file.Path).Errorf("several mutations would be written to %s", file.Path)
+		}
+		seen[file.Path] = true
+
+		if _, err := os.Stat(file.Path); err == nil && !force {
+			return oops.In("explode").With("file", file.Path).Hint("use --force to overwrite it").Errorf("%s already exists", file.Path)
+		}
+	}
+
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file.Path, file.Contents, 0644); err != nil {
+			return err
+		}
+		fmt.Println(file.Path)
+	}
 	return nil
 }
+
+// writeExplodedSet writes every mutation of the set to its own file, with the header of the set.
+func writeExplodedSet(out_dir string, set *mutations.MutationSet, force bool) error {
+	var files []explodedFile
+	for _, mut := range set.SortedMutations() {
+		var buf bytes.Buffer
+		if err := set.WriteMutationYaml(&buf, mut); err != nil {
+			return err
+		}
+		files = append(files, explodedFile{Path: explodedPath(out_dir, mut.Name), Contents: buf.Bytes()})
+	}
+	return writeExplodedFiles(files, force)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+// An exploded file reads back as the same mutations, and keeps its header and comments.
+func TestExplodeRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	source := []byte(`__namespace: shop
+__revision: 2
+
+# the users
+users:
+  # creates the table
+  sql:
+    - |
+      create table users (
+        id int
+      );
+  children:
+    email:
+      sql:
+        - |
+          alter table users
+            add column email text;
+      meta:
+        - grant select on users to public;
+      children:
+        idx:
+          needs: [users]
+          sql:
+            - create index users_email on users (email);
+`)
+	file := filepath.Join(dir, "mutations.yml")
+	if err := os.WriteFile(file, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out_dir := filepath.Join(dir, "exploded")
+	files, err := explodeYaml(out_dir, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExplodedFiles(files, false); err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := os.ReadFile(filepath.Join(out_dir, "users", "email.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(email), "\n    - |\n      alter table users\n        add column email text;\n") {
+		t.Errorf("expected the block scalar at the indentation of the mutation, got\n%s", email)
+	}
+	users, err := os.ReadFile(filepath.Join(out_dir, "users.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(users), "# the users\n") || !strings.Contains(string(users), "# creates the table\n") {
+		t.Errorf("expected the comments to be kept, got\n%s", users)
+	}
+
+	original, err := mutations.LoadYamlMutations(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected, err := mutations.LoadYamlMutations(out_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, actual := original.Latest("shop"), collected.Latest("shop")
+	if actual == nil || actual.Revision != expected.Revision {
+		t.Fatalf("expected namespace shop at revision %d", expected.Revision)
+	}
+	for _, mut := range expected.SortedMutations() {
+		other, ok := actual.GetMutation(mut.Name)
+		if !ok {
+			t.Errorf("%s is missing", mut.Name)
+			continue
+		}
+		if other.SqlHash() != mut.SqlHash() || other.MetaHash() != mut.MetaHash() {
+			t.Errorf("%s does not have the same statements", mut.Name)
+		}
+		if !slices.Equal(mutations.ExplicitNeeds(mut.Name, other.Needs), mutations.ExplicitNeeds(mut.Name, mut.Needs)) {
+			t.Errorf("%s does not have the same needs", mut.Name)
+		}
+	}
+	if len(actual.SortedMutations()) != len(expected.SortedMutations()) {
+		t.Errorf("expected %d mutations, got %d", len(expected.SortedMutations()), len(actual.SortedMutations()))
+	}
+}
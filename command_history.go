@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+type HistoryCmd struct {
+	Uri       string `arg:"" help:"Database uri."`
+	Namespace string `short:"n" help:"Only show the applies of this namespace."`
+	Mutation  string `short:"m" help:"Only show the applies that ran the mutations matching this glob."`
+	Limit     int    `short:"l" default:"20" help:"How many applies to show, 0 for all of them."`
+	Verbose   bool   `short:"v" help:"Verbose output."`
+}
+
+// Run lists the applies recorded in __dmut__.history, the most recent first.
+func (h HistoryCmd) Run(ctx context.Context) error {
+	runner, err := mutations.NewPgRunner(ctx, h.Uri, h.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	history, err := runner.GetHistory(ctx, h.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var shown = 0
+	for _, entry := range history {
+		if h.Limit > 0 && shown >= h.Limit {
+			break
+		}
+		if h.Mutation != "" && !entry.RanMutation(h.Mutation) {
+			continue
+		}
+		entry.Print(os.Stdout)
+		shown++
+	}
+	return nil
+}
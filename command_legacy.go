@@ -2,142 +2,97 @@ package main
 
 import (
 	"context"
-	"os"
-	"strings"
 
 	"github.com/ceymard/dmut/v2/mutations"
-	"github.com/goccy/go-yaml"
-	"github.com/jackc/pgx/v4"
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
 )
 
-// Legacy commands that are no longer supported
+// Migrate a database from a dmut version prior to 1.0.0
 
 type LegacyCmd struct {
-	Host string `arg:"" help:"Database host."`
+	Host      string `arg:"" help:"Database host."`
+	OutDir    string `required:"" short:"o" name:"out-dir" help:"Output directory to write the mutations to."`
+	Namespace string `short:"n" help:"Namespace of the migrated mutations."`
+	Force     bool   `short:"f" help:"Overwrite existing files."`
+	Record    bool   `short:"r" help:"Record the mutations in __dmut__.mutations and drop the legacy dmut schema, in the same transaction."`
+	Verbose   bool   `short:"v" help:"Verbose output."`
 }
 
-func (c LegacyCmd) Run() error {
-	conn, err := pgx.Connect(context.Background(), c.Host)
+func (c LegacyCmd) Run(ctx context.Context) error {
+	runner, err := mutations.NewPgRunner(ctx, c.Host, c.Verbose)
 	if err != nil {
 		return err
 	}
-	defer conn.Close(context.Background())
+	defer runner.Close()
 
-	rows, err := conn.Query(context.Background(), "SELECT hash, name, up, down, children FROM dmut.mutations")
-	if err != nil {
+	if ok, err := runner.HasLegacySchema(ctx); err != nil {
 		return err
+	} else if !ok {
+		return oops.In("legacy").Errorf("there is no dmut.mutations table in the database")
 	}
 
-	type LegacyMutation struct {
-		Hash     string `db:"hash"`
-		Needs    []string
-		Name     string   `db:"name"`
-		Up       []string `db:"up"`
-		Down     []string `db:"down"`
-		Children []string `db:"children"`
+	set, err := runner.GetLegacyMutations(ctx, c.Namespace)
+	if err != nil {
+		return err
 	}
 
-	var mut_map = make(map[string]*LegacyMutation)
-
-	for rows.Next() {
-		var mut LegacyMutation = LegacyMutation{}
-
-		err = rows.Scan(&mut.Hash, &mut.Name, &mut.Up, &mut.Down, &mut.Children)
-		if err != nil {
-			return err
-		}
-
-		if mut.Name != "dmut.base" && mut.Name != "dmut" {
-			mut_map[mut.Name] = &mut
-		}
+	if err := writeExplodedSet(c.OutDir, set, c.Force); err != nil {
+		return err
 	}
 
-	for _, mut := range mut_map {
-		for _, child := range mut.Children {
-			child_mut := mut_map[child]
-			child_mut.Needs = append(child_mut.Needs, mut.Name)
-		}
+	if !c.Record {
+		return nil
 	}
 
-	type YamlLegacyStatementUpDown struct {
-		Up   string `yaml:"up"`
-		Down string `yaml:"down"`
+	// the mutations are read back from the files, so that what is recorded is what will be applied later on
+	muts, err := mutations.LoadYamlMutations(c.OutDir)
+	if err != nil {
+		return err
 	}
-	type YamlLegacyStatement interface{}
-	type YamlLegacyMigration struct {
-		Needs []string `yaml:"needs,omitempty,flow"`
-		Sql   []YamlLegacyStatement
+
+	var names = muts.Namespaces()
+
+	if err := runner.Begin(ctx); err != nil {
+		return err
 	}
-	type YamlFile map[string]*YamlLegacyMigration
-
-	var yaml_file = make(YamlFile)
-	for _, mut := range mut_map {
-		var statements = make([]YamlLegacyStatement, 0)
-		if len(mut.Up) != len(mut.Down) {
-
-			for _, up := range mut.Up {
-				statements = append(statements, mutations.MutationStatement{
-					Up: replaceLineComments(up),
-					// Down: mut.Down[len(mut.Down)-i-1],
-				})
-			}
-
-			// add down in reverse order
-			for i := len(mut.Down) - 1; i >= 0; i-- {
-				down := mut.Down[i]
-				statements = append(statements, mutations.MutationStatement{
-					Down: replaceLineComments(down),
-				})
-			}
-		} else {
-			for i, up := range mut.Up {
-
-				up = replaceLineComments(up)
-				down := replaceLineComments(mut.Down[len(mut.Down)-i-1])
-
-				if _, err := mutations.AutoDowner.ParseAndGetDefault(up); err == nil {
-					// if we can undo it, just put the string
-					// os.Stdout.WriteString(up + "\n")
-					statements = append(statements, up)
-				} else {
-					statements = append(statements, mutations.MutationStatement{
-						Up:   up,
-						Down: down,
-					})
-				}
-			}
+
+	// the locks outlive the transaction, they are released once it is over
+	defer func() {
+		if err := runner.Unlock(ctx); err != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err)
 		}
+	}()
 
-		yaml_file[mut.Name] = &YamlLegacyMigration{
-			Needs: mut.Needs,
-			Sql:   statements,
+	if err := c.record(ctx, runner, muts, names); err != nil {
+		if err2 := runner.Rollback(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
 		}
+		return err
 	}
 
-	yaml.NewEncoder(os.Stdout).Encode(yaml_file)
-	// yaml_file := ast.
-	// yaml.NewEncoder()
-	// yaml_file.Kind = yaml.MappingNode
-	// yaml_file.Tag = "!!map"
-	// yaml_file.Content = make([]*yaml.Node, 0)
-
-	// for _, mut := range mut_map {
-	// 	mut_node := yaml.Node{}
-	// 	mut_node.Kind = yaml.MappingNode
-	// 	mut_node.Tag = "!!map"
-	// 	mut_node.Content = make([]*yaml.Node, 0)
-	// }
-	return nil
+	return runner.Commit(ctx)
 }
 
-// Replace line comments that start with --, keep the comment but put it between /* and */
-func replaceLineComments(str string) string {
-	lines := strings.Split(str, "\n")
-	for i, line := range lines {
-		if idx := strings.Index(line, "--"); idx >= 0 {
-			comment := line[idx+2:]
-			lines[i] = line[:idx] + "/* " + comment + " */"
+func (c LegacyCmd) record(ctx context.Context, runner *mutations.PgRunner, muts *mutations.MutationNamespace, names []string) error {
+	// dmut's own schema has to exist before anything can be recorded
+	if err := runner.Lock(ctx, mutations.DMUT_NAMESPACE, 0); err != nil {
+		return err
+	}
+	if err := mutations.Bootstrap(ctx, runner, &mutations.MutationRunnerOptions{Verbose: c.Verbose}); err != nil {
+		return err
+	}
+	for _, namespace := range names {
+		if err := runner.Lock(ctx, namespace, 0); err != nil {
+			return err
+		}
+		// nothing runs when overriding, not even outside of the transaction
+		if _, err := mutations.RunMutations(ctx, runner, muts.Latest(namespace), &mutations.MutationRunnerOptions{
+			Verbose:  c.Verbose,
+			Override: true,
+		}); err != nil {
+			return err
 		}
 	}
-	return strings.Join(lines, "\n")
+	return runner.DropLegacySchema(ctx)
 }
@@ -1,12 +1,82 @@
 package main
 
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/ceymard/dmut/v2/mutations"
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
 // Overwrite the destination database with the definition provided in the yaml paths
 
 type OverwriteCmd struct {
-	Host  string   `arg:"" help:"Database host."`
-	Paths []string `arg:"" help:"Paths to the mutation files"`
+	Host    string   `arg:"" help:"Database host."`
+	Paths   []string `arg:"" help:"Paths to the mutation files"`
+	Force   bool     `short:"f" help:"Record the mutations even if some of the objects they create are missing."`
+	Verbose bool     `short:"v" help:"Verbose output."`
+	Dry     bool     `short:"d" help:"Dry run, only check the objects and don't record the mutations."`
+
+	LockTimeout      time.Duration `name:"lock-timeout" help:"How long to wait for another dmut applying to the same database, and for the locks taken by the statements. Forever by default."`
+	StatementTimeout time.Duration `name:"statement-timeout" help:"How long a statement may run, unless its mutation has a timeout. The server's statement_timeout by default."`
+
+	VarsFlags
 }
 
-func (o OverwriteCmd) Run() error {
+func (o OverwriteCmd) Run(ctx context.Context) error {
+	vars, err := o.Vars()
+	if err != nil {
+		return err
+	}
+
+	muts, err := mutations.LoadYamlMutationsWithVars(vars, o.Paths...)
+	if err != nil {
+		return err
+	}
+
+	runner, err := mutations.NewPgRunner(ctx, o.Host, o.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	var names = muts.Keys()
+	slices.Sort(names)
+
+	var missing_count = 0
+	for _, namespace := range names {
+		if namespace == mutations.DMUT_NAMESPACE {
+			continue
+		}
+		set := muts.Latest(namespace)
+		missing, err := runner.MissingObjects(ctx, set)
+		if err != nil {
+			return err
+		}
+		for _, mut := range set.SortedMutations() {
+			for _, obj := range missing[mut] {
+				runner.Logger().Println(au.BrightRed("✗"), mut.DisplayName(), "is missing", obj.String())
+				missing_count++
+			}
+		}
+	}
+
+	if missing_count > 0 && !o.Force {
+		return oops.In("overwrite").Hint("use --force to record the mutations anyway").Errorf("%d objects are missing from the database", missing_count)
+	}
+
+	if err := mutations.RunAllMutations(ctx, runner, muts, &mutations.MutationRunnerOptions{
+		Verbose:  o.Verbose,
+		Commit:   !o.Dry,
+		Override: true,
+
+		LockTimeout:      o.LockTimeout,
+		StatementTimeout: o.StatementTimeout,
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/ceymard/dmut/v2/mutations"
+)
+
+type PlanCmd struct {
+	Uri     string   `arg:"" help:"Database uri."`
+	Paths   []string `arg:"" help:"Paths to the mutations."`
+	All     bool     `short:"a" help:"Plan all revisions when the database has none, not just the latest one."`
+	Verbose bool     `short:"v" help:"Verbose output."`
+	Json    bool     `short:"j" help:"Output the plan as json."`
+	Only    []string `name:"only" help:"Only plan the mutations matching these selectors, and the parents they need."`
+	Exclude []string `name:"exclude" help:"Leave the mutations matching these selectors as they are in the database."`
+
+	VarsFlags
+}
+
+// Run only reads the state of the database, so that it can be used on read-only replicas.
+func (p PlanCmd) Run(ctx context.Context) error {
+	mutations.LogOutput = os.Stderr
+
+	vars, err := p.Vars()
+	if err != nil {
+		return err
+	}
+
+	muts, err := mutations.LoadYamlMutationsWithVars(vars, p.Paths...)
+	if err != nil {
+		return err
+	}
+
+	runner, err := mutations.NewPgRunner(ctx, p.Uri, p.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	plans, err := mutations.PlanAllMutations(ctx, runner, muts, &mutations.MutationRunnerOptions{
+		All:     p.All,
+		Only:    p.Only,
+		Exclude: p.Exclude,
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.Json {
+		return mutations.WritePlansJson(os.Stdout, plans)
+	}
+
+	for _, plan := range plans {
+		plan.Print(os.Stdout)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ceymard/dmut/v2/mutations"
+	au "github.com/logrusorgru/aurora"
+)
+
+// Recompute the hashes stored in the database, after the way dmut hashes statements changed
+
+type RehashCmd struct {
+	Uri     string `arg:"" help:"Database uri."`
+	Verbose bool   `short:"v" help:"Verbose output."`
+	Dry     bool   `short:"d" help:"Dry run, only list the mutations whose hash changed."`
+}
+
+func (c RehashCmd) Run(ctx context.Context) error {
+	runner, err := mutations.NewPgRunner(ctx, c.Uri, c.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	if err := runner.Begin(ctx); err != nil {
+		return err
+	}
+
+	// the locks outlive the transaction, they are released once it is over
+	defer func() {
+		if err := runner.Unlock(ctx); err != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err)
+		}
+	}()
+
+	if err := c.rehash(ctx, runner); err != nil {
+		if err2 := runner.Rollback(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
+		return err
+	}
+
+	if c.Dry {
+		return runner.Rollback(ctx)
+	}
+	return runner.Commit(ctx)
+}
+
+func (c RehashCmd) rehash(ctx context.Context, runner *mutations.PgRunner) error {
+	// the tracking table needs its hash columns
+	if err := runner.Lock(ctx, mutations.DMUT_NAMESPACE, 0); err != nil {
+		return err
+	}
+	if err := mutations.Bootstrap(ctx, runner, &mutations.MutationRunnerOptions{Verbose: c.Verbose}); err != nil {
+		return err
+	}
+
+	namespaces, err := runner.GetDBNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		if err := runner.Lock(ctx, namespace, 0); err != nil {
+			return err
+		}
+		set, err := runner.GetDBMutationsFromDb(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		changed, err := runner.Rehash(ctx, set)
+		if err != nil {
+			return err
+		}
+		for _, mut := range changed {
+			runner.Logger().Println(au.BrightYellow("↻"), mut.DisplayName(), "hash changed")
+		}
+		runner.Logger().Println(au.BrightGreen("✓"), "rehashed", set.Size(), "mutations of namespace", au.BrightMagenta(namespace).String())
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"github.com/ceymard/dmut/v2/mutations"
+	"github.com/samber/oops"
+)
+
+type StatusCmd struct {
+	Uri        string   `arg:"" help:"Database uri."`
+	Paths      []string `arg:"" help:"Paths to the mutations."`
+	Namespaces []string `short:"n" name:"namespace" help:"Only check these namespaces."`
+	Verbose    bool     `short:"v" help:"Verbose output."`
+
+	VarsFlags
+}
+
+// Run exits with an error when the database is not up to date, so that it can be used as a health check.
+func (s StatusCmd) Run(ctx context.Context) error {
+	vars, err := s.Vars()
+	if err != nil {
+		return err
+	}
+
+	muts, err := mutations.LoadYamlMutationsWithVars(vars, s.Paths...)
+	if err != nil {
+		return err
+	}
+	if len(s.Namespaces) > 0 {
+		if err := muts.KeepNamespaces(s.Namespaces); err != nil {
+			return err
+		}
+	}
+
+	runner, err := mutations.NewPgRunner(ctx, s.Uri, s.Verbose)
+	if err != nil {
+		return err
+	}
+	defer runner.Close()
+
+	statuses, err := mutations.GetStatus(ctx, runner, muts)
+	if err != nil {
+		return err
+	}
+
+	var drifted []string
+	for _, status := range statuses {
+		// the namespaces only the database has are not filtered by KeepNamespaces
+		if len(s.Namespaces) > 0 && !slices.Contains(s.Namespaces, status.Namespace) {
+			continue
+		}
+		status.Print(os.Stdout)
+		if status.HasDrift() {
+			drifted = append(drifted, status.Namespace)
+		}
+	}
+
+	if len(drifted) > 0 {
+		return oops.In("status").With("namespaces", drifted).Hint("run dmut plan to see what apply would do").Errorf("%d namespaces are not up to date", len(drifted))
+	}
+	return nil
+}
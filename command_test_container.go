@@ -20,6 +20,10 @@ type TestCmd struct {
 	Username string   `short:"u" name:"test-username" help:"Username to test on."`
 	Password string   `short:"p" name:"test-password" help:"Password to test on."`
 	Paths    []string `arg:"" help:"Paths to test."`
+
+	Namespaces []string `short:"n" name:"namespace" help:"Only load and test these namespaces."`
+
+	VarsFlags
 }
 
 type Printer struct{}
@@ -28,7 +32,7 @@ func (Printer) Accept(l testcontainers.Log) {
 	fmt.Print(string(l.Content))
 }
 
-func (t TestCmd) Run() error {
+func (t TestCmd) Run(ctx context.Context) error {
 	var image = "postgres:14"
 	if t.Image != "" {
 		image = t.Image
@@ -43,28 +47,59 @@ func (t TestCmd) Run() error {
 		t.Password = "test"
 	}
 
-	_, err := mutations.LoadYamlMutations(t.Paths...)
+	vars, err := t.Vars()
 	if err != nil {
 		return err
 	}
 
+	muts, err := mutations.LoadYamlMutationsWithVars(vars, t.Paths...)
+	if err != nil {
+		return err
+	}
+	if len(t.Namespaces) > 0 {
+		if err := muts.KeepNamespaces(t.Namespaces); err != nil {
+			return err
+		}
+	}
+
 	log.Println("testing mutations on", image)
-	ctx := context.Background()
+	container, uri, err := startTestContainer(ctx, image, t.Database, t.Username, t.Password, t.Verbose)
+	if err != nil {
+		return err
+	}
+	// the container is removed even when interrupted
+	defer container.Terminate(context.WithoutCancel(ctx))
+
+	if err := mutations.ReadAndRunMutations(ctx, uri, t.Paths, mutations.MutationRunnerOptions{
+		Verbose: t.Verbose,
+		Commit:  false,
+		All:     t.All,
+		Vars:    vars,
+
+		Namespaces: t.Namespaces,
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// startTestContainer starts a postgres container, and returns it once it accepts connections.
+func startTestContainer(ctx context.Context, image string, database string, username string, password string, verbose bool) (*postgres.PostgresContainer, string, error) {
 	container, err := postgres.Run(ctx,
 		image,
-		postgres.WithDatabase(t.Database),
-		postgres.WithUsername(t.Username),
-		postgres.WithPassword(t.Password),
+		postgres.WithDatabase(database),
+		postgres.WithUsername(username),
+		postgres.WithPassword(password),
 		testcontainers.WithWaitStrategy(
 			wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(5*time.Second),
 		),
 	)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	defer container.Terminate(ctx)
 
-	if t.Verbose {
+	if verbose {
 		printer := Printer{}
 		container.FollowOutput(printer)
 		container.StartLogProducer(ctx)
@@ -74,17 +109,9 @@ func (t TestCmd) Run() error {
 
 	uri, err := container.ConnectionString(ctx)
 	if err != nil {
-		return err
+		container.Terminate(context.WithoutCancel(ctx))
+		return nil, "", err
 	}
 	log.Println("test container URI:", uri)
-
-	if err := mutations.ReadAndRunMutations(uri, t.Paths, mutations.MutationRunnerOptions{
-		Verbose: t.Verbose,
-		Commit:  false,
-		All:     t.All,
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return container, uri, nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ceymard/dmut/v2/mutations"
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
+// Verify that the objects of a database are the ones its recorded mutations made
+
+type VerifyCmd struct {
+	Uri        string   `arg:"" help:"Database uri."`
+	Scratch    string   `name:"scratch" help:"Uri of an empty database to replay the mutations on. A postgres container of the same major version is started when not given."`
+	Image      string   `short:"i" name:"test-image" help:"Postgres image of the container, instead of the version of the database."`
+	Namespaces []string `short:"n" name:"namespace" help:"Only verify these namespaces."`
+	Verbose    bool     `short:"v" help:"Verbose output."`
+}
+
+func (v VerifyCmd) Run(ctx context.Context) error {
+	target, err := mutations.NewPgRunner(ctx, v.Uri, v.Verbose)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	muts, err := target.GetDBMutationNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	if len(v.Namespaces) > 0 {
+		if err := muts.KeepNamespaces(v.Namespaces); err != nil {
+			return err
+		}
+	}
+
+	var scratch_uri = v.Scratch
+	if scratch_uri == v.Uri {
+		return oops.In("verify").Errorf("the scratch database cannot be the verified one")
+	}
+	if scratch_uri == "" {
+		var image = v.Image
+		if image == "" {
+			version, err := target.ServerVersion(ctx)
+			if err != nil {
+				return err
+			}
+			image = fmt.Sprintf("postgres:%d", version)
+		}
+
+		log.Println("replaying mutations on", image)
+		container, uri, err := startTestContainer(ctx, image, "scratch", "scratch", "scratch", v.Verbose)
+		if err != nil {
+			return err
+		}
+		// the container is removed even when interrupted
+		defer container.Terminate(context.WithoutCancel(ctx))
+		scratch_uri = uri
+	}
+
+	scratch, err := mutations.NewPgRunner(ctx, scratch_uri, v.Verbose)
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+
+	results, err := mutations.Verify(ctx, target, scratch, muts)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		result.Print(os.Stdout)
+	}
+
+	if len(results) > 0 {
+		return oops.In("verify").Hint("the objects were changed outside of dmut, or by a different version of postgres").Errorf("%d mutations do not match the database", len(results))
+	}
+	fmt.Println(au.BrightGreen("≡"), "the database matches its mutations")
+	return nil
+}
@@ -6,11 +6,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alecthomas/kong"
+	"github.com/ceymard/dmut/v2/mutations"
 	"github.com/samber/oops"
 )
 
@@ -19,9 +23,20 @@ var VERSION = "1.0.0"
 type CLI struct {
 	Collect CollectCmd `cmd:"" help:"Collect all paths into a single yaml file."`
 	Apply   ApplyCmd   `cmd:"" help:"Apply the mutations to the database."`
+	Plan    PlanCmd    `cmd:"" help:"Print the statements that apply would run, without touching the database."`
+	Status  StatusCmd  `cmd:"" help:"Tell which namespaces of the database differ from the local mutations, failing if any does."`
 	Down    DownCmd    `cmd:"" help:"Down the mutations from the database."`
-	Version VersionCmd `cmd:"" help:"Show the version."`
-	Explode ExplodeCmd `cmd:"" help:"Explode mutations into individual yaml files."`
+
+	Overwrite OverwriteCmd `cmd:"" help:"Record the mutations as applied without running them, after checking that the objects they create exist."`
+	History   HistoryCmd   `cmd:"" help:"Show the applies recorded in the database, and what they ran."`
+	Verify    VerifyCmd    `cmd:"" help:"Replay the mutations recorded in the database on an empty one, and compare the objects they make with the database's."`
+	Rehash    RehashCmd    `cmd:"" help:"Recompute the hashes stored in the database, after the way dmut hashes statements changed."`
+	Version   VersionCmd   `cmd:"" help:"Show the version."`
+	Explode   ExplodeCmd   `cmd:"" help:"Explode mutations into individual yaml files."`
+
+	CreateRevision CreateRevisionCmd `cmd:"" help:"Create the next revision from a database or a previous revision file and the local mutations."`
+	Diff           DiffCmd           `cmd:"" help:"Show the differences between two sets of mutations, from databases, directories or yaml files."`
+	Baseline       BaselineCmd       `cmd:"" help:"Read the catalog of an existing database into mutations."`
 
 	Test   TestCmd   `cmd:"" help:"Test the mutations on an empty test database that will be created on the fly."`
 	Legacy LegacyCmd `cmd:"" help:"Extract a yaml from a legacy dmut system prior to version 1.0.0"`
@@ -29,14 +44,34 @@ type CLI struct {
 }
 
 type CollectCmd struct {
-	Outfile string   `arg:"" help:"Output YAML file."`
+	Outfile string   `arg:"" help:"Output YAML file, or - for stdout."`
 	Paths   []string `arg:"" help:"Paths to collect."`
+
+	VarsFlags
 }
 
 func (c CollectCmd) Run() error {
-	_ = c.Outfile
-	_ = c.Paths
-	return nil
+	vars, err := c.Vars()
+	if err != nil {
+		return err
+	}
+
+	muts, err := mutations.LoadYamlMutationsWithVars(vars, c.Paths...)
+	if err != nil {
+		return err
+	}
+
+	if c.Outfile == "-" {
+		return muts.WriteYaml(os.Stdout)
+	}
+
+	f, err := os.Create(c.Outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return muts.WriteYaml(f)
 }
 
 type VersionCmd struct{}
@@ -48,13 +83,22 @@ func (VersionCmd) Run() error {
 
 func main() {
 	cli := CLI{}
+	mutations.Version = VERSION
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	// an interrupted command rolls back its transaction instead of leaving it to the server
+	signal_ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	ctx := kong.Parse(&cli,
 		kong.Name("dmut"),
 		kong.Description("Database mutation runner. Collect, test, and apply migrations."),
 		kong.UsageOnError(),
+		kong.BindTo(signal_ctx, (*context.Context)(nil)),
 	)
-	if err := ctx.Run(); err != nil {
+	err := ctx.Run()
+	stop()
+	if err != nil {
 		if oops_err, ok := err.(oops.OopsError); ok {
 			fmt.Printf("%+v\n", oops_err)
 
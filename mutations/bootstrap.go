@@ -0,0 +1,101 @@
+package mutations
+
+import (
+	"context"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
+// dmut's own mutations hold the tracking table, and are applied in a phase of their own before any other namespace.
+//
+// The tracking table must survive upgrades of dmut : its mutations in __dmut__.yml are never changed once released,
+// new columns are added by new mutations (dmut.mutations.roles, dmut.mutations.vars...). A bootstrap that would down
+// some of its sql is thus a bug, or a database set up by a more recent dmut, and is refused.
+
+// LoadDmutMutations reads dmut's own mutations, that are embedded in the binary.
+func LoadDmutMutations() (*MutationSet, error) {
+	var res = NewMutationNamespace()
+
+	if err := browseFs(res, dmut_mutations, ".", nil); err != nil {
+		return nil, err
+	}
+	if err := res.ResolveDependencies(); err != nil {
+		return nil, err
+	}
+	if err := res.EnsureContinuousRevisions(); err != nil {
+		return nil, err
+	}
+
+	return res.Latest(DMUT_NAMESPACE), nil
+}
+
+// getBootstrapPlan computes what has to be run for the tracking table to be up to date.
+func getBootstrapPlan(ctx context.Context, runner Executor, local *MutationSet) (*MutationPlan, error) {
+	distant, err := runner.GetDBMutationsFromDb(ctx, DMUT_NAMESPACE)
+	if err != nil {
+		return nil, err
+	}
+	// dmut's own mutations never change, they are compared on their statements whatever version hashed them
+	for mut := range distant.AllMutations() {
+		mut.StoredSqlHash, mut.StoredMetaHash = "", ""
+	}
+
+	var unknown []string
+	for mut := range distant.AllMutations() {
+		if !local.HasMutation(mut.Name) {
+			unknown = append(unknown, mut.Name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, oops.In("bootstrap").
+			With("mutations", unknown).
+			Hint("upgrade dmut").
+			Errorf("the tracking table was set up by a more recent version of dmut")
+	}
+
+	plan := NewMutationPlan(local, distant)
+	if plan.SqlDown.Size() > 0 {
+		return nil, oops.In("bootstrap").
+			With("mutations", plan.SqlDown.Keys()).
+			Errorf("refusing to down the sql of dmut's own tracking table")
+	}
+	return plan, nil
+}
+
+// Bootstrap creates or upgrades the tracking table. A transaction should be started before calling this function.
+func Bootstrap(ctx context.Context, runner Executor, opts ...*MutationRunnerOptions) error {
+	var options = MutationRunnerOptions{}
+	options.Merge(opts...)
+
+	local, err := LoadDmutMutations()
+	if err != nil {
+		return err
+	}
+
+	plan, err := getBootstrapPlan(ctx, runner, local)
+	if err != nil {
+		return err
+	}
+	if !plan.HasChanges() {
+		return nil
+	}
+
+	runner.Logger().Println(au.BrightGreen("→"), "bootstrapping the tracking table")
+	if options.OnPlan != nil {
+		options.OnPlan(plan)
+	}
+	if err := plan.Run(ctx, runner); err != nil {
+		return err
+	}
+	return runner.SaveMutations(ctx, local)
+}
+
+// PlanBootstrap computes, without running anything, the plan that Bootstrap would follow.
+func PlanBootstrap(ctx context.Context, runner Executor) (*MutationPlan, error) {
+	local, err := LoadDmutMutations()
+	if err != nil {
+		return nil, err
+	}
+	return getBootstrapPlan(ctx, runner, local)
+}
@@ -0,0 +1,43 @@
+package mutations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A tracking table set up by an older dmut is upgraded without downing anything.
+func TestBootstrapUpgrade(t *testing.T) {
+	local, err := LoadDmutMutations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	older := NewMutationSet(DMUT_NAMESPACE, local.Revision, "")
+	for mut := range local.AllMutations() {
+		if mut.Name != "dmut.mutations.vars" {
+			older.AddMutation(mut.clone())
+		}
+	}
+	if err := older.ResolveDependencies(); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := NewMutationPlan(local, older)
+	if plan.SqlDown.Size() != 0 || plan.MetaDown.Size() != 0 {
+		t.Errorf("nothing should be downed, got %v and %v", plan.SqlDown.Keys(), plan.MetaDown.Keys())
+	}
+	if keys := plan.SqlUp.Keys(); len(keys) != 1 || keys[0] != "dmut.mutations.vars" {
+		t.Errorf("only dmut.mutations.vars should be upped, got %v", keys)
+	}
+}
+
+func TestReservedNamespace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte("__namespace: __dmut__\nother:\n  sql:\n    - create schema other;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadYamlMutations(file); err == nil {
+		t.Errorf("expected an error for the %s namespace", DMUT_NAMESPACE)
+	}
+}
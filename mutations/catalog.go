@@ -0,0 +1,179 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CatalogObject is a database object that a statement creates, as inferred from its automatic down.
+type CatalogObject struct {
+	Kind  string
+	Name  string
+	Table string // for objects that belong to a table, like policies, triggers, columns or constraints
+}
+
+func (o CatalogObject) String() string {
+	if o.Table != "" {
+		return fmt.Sprintf("%s %s on %s", o.Kind, o.Name, o.Table)
+	}
+	return fmt.Sprintf("%s %s", o.Kind, o.Name)
+}
+
+// The kinds of objects that can be checked against the catalog, as they appear in their DROP statement.
+var catalog_kinds = [][]string{
+	{"materialized", "view"},
+	{"foreign", "table"},
+	{"table"},
+	{"view"},
+	{"index"},
+	{"sequence"},
+	{"type"},
+	{"domain"},
+	{"schema"},
+	{"role"},
+	{"extension"},
+	{"function"},
+	{"procedure"},
+	{"aggregate"},
+	{"policy"},
+	{"trigger"},
+}
+
+// ObjectCreatedBy returns the object that a statement creates, if it is one that can be checked.
+func ObjectCreatedBy(stmt string) (CatalogObject, bool) {
+	down, err := AutoDowner.ParseAndGetDefault(stmt)
+	if err != nil {
+		return CatalogObject{}, false
+	}
+	tokens, err := split(down)
+	if err != nil {
+		return CatalogObject{}, false
+	}
+	var is = func(i int, value string) bool {
+		return i < len(tokens) && strings.EqualFold(tokens[i].Value, value)
+	}
+	var value = func(i int) string {
+		if i < len(tokens) {
+			return tokens[i].Value
+		}
+		return ""
+	}
+
+	// alter table <table> drop column|constraint <name>
+	if is(0, "alter") && is(1, "table") && is(3, "drop") && (is(4, "column") || is(4, "constraint")) {
+		return CatalogObject{Kind: strings.ToLower(value(4)), Name: value(5), Table: value(2)}, true
+	}
+
+	if !is(0, "drop") {
+		return CatalogObject{}, false
+	}
+
+	for _, kind := range catalog_kinds {
+		matches := true
+		for i, word := range kind {
+			matches = matches && is(i+1, word)
+		}
+		if !matches {
+			continue
+		}
+
+		var (
+			pos = len(kind) + 1
+			obj = CatalogObject{Kind: strings.Join(kind, " "), Name: value(pos)}
+		)
+		if is(pos+1, "on") {
+			obj.Table = value(pos + 2)
+		}
+		return obj, obj.Name != ""
+	}
+
+	return CatalogObject{}, false
+}
+
+// CreatedObjects lists the objects that the sql and meta statements of a mutation claim to create.
+func (mut *Mutation) CreatedObjects() []CatalogObject {
+	var res []CatalogObject
+	for _, role := range mut.Roles {
+		res = append(res, CatalogObject{Kind: "role", Name: pgx.Identifier{role}.Sanitize()})
+	}
+	for _, stmt := range slices.Concat(mut.Sql, mut.Meta) {
+		if obj, ok := ObjectCreatedBy(stmt.Up); ok {
+			res = append(res, obj)
+		}
+	}
+	return res
+}
+
+const sql_ident_parts = `(select parse_ident($1) as parts)`
+
+var sql_routine_exists = `select exists (
+	select 1
+	from pg_catalog.pg_proc p, ` + sql_ident_parts + ` n
+	where p.proname = parts[array_upper(parts, 1)]
+		and (
+			array_length(parts, 1) = 1 and pg_catalog.pg_function_is_visible(p.oid)
+			or p.pronamespace = to_regnamespace(quote_ident(parts[1]))
+		)
+)`
+
+// queries that check for the existence of objects, with $1 as the object name and $2 as its table
+var catalog_queries = map[string]string{
+	"materialized view": `select to_regclass($1) is not null`,
+	"foreign table":     `select to_regclass($1) is not null`,
+	"table":             `select to_regclass($1) is not null`,
+	"view":              `select to_regclass($1) is not null`,
+	"index":             `select to_regclass($1) is not null`,
+	"sequence":          `select to_regclass($1) is not null`,
+	"type":              `select to_regtype($1) is not null`,
+	"domain":            `select to_regtype($1) is not null`,
+	"schema":            `select to_regnamespace($1) is not null`,
+	"role":              `select to_regrole($1) is not null`,
+	"extension":         `select exists (select 1 from pg_catalog.pg_extension, ` + sql_ident_parts + ` n where extname = parts[1])`,
+	"policy":            `select exists (select 1 from pg_catalog.pg_policy, ` + sql_ident_parts + ` n where polname = parts[1] and polrelid = to_regclass($2))`,
+	"trigger":           `select exists (select 1 from pg_catalog.pg_trigger, ` + sql_ident_parts + ` n where tgname = parts[1] and tgrelid = to_regclass($2))`,
+	"column":            `select exists (select 1 from pg_catalog.pg_attribute, ` + sql_ident_parts + ` n where attname = parts[1] and attrelid = to_regclass($2) and not attisdropped)`,
+	"constraint":        `select exists (select 1 from pg_catalog.pg_constraint, ` + sql_ident_parts + ` n where conname = parts[1] and conrelid = to_regclass($2))`,
+	"function":          sql_routine_exists,
+	"procedure":         sql_routine_exists,
+	"aggregate":         sql_routine_exists,
+}
+
+// ObjectExists checks the catalog for an object.
+func (r *PgRunner) ObjectExists(ctx context.Context, obj CatalogObject) (bool, error) {
+	sql, ok := catalog_queries[obj.Kind]
+	if !ok {
+		return true, nil
+	}
+
+	var args = []any{obj.Name}
+	if strings.Contains(sql, "$2") {
+		args = append(args, obj.Table)
+	}
+
+	var exists bool
+	if err := r.conn.QueryRow(ctx, sql, args...).Scan(&exists); err != nil {
+		return false, wrapPgError(err, sql)
+	}
+	return exists, nil
+}
+
+// MissingObjects lists, for every mutation of the set, the objects it claims to create that are not in the catalog.
+func (r *PgRunner) MissingObjects(ctx context.Context, set *MutationSet) (map[*Mutation][]CatalogObject, error) {
+	var res = make(map[*Mutation][]CatalogObject)
+	for _, mut := range set.SortedMutations() {
+		for _, obj := range mut.CreatedObjects() {
+			exists, err := r.ObjectExists(ctx, obj)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				res[mut] = append(res[mut], obj)
+			}
+		}
+	}
+	return res, nil
+}
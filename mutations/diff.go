@@ -0,0 +1,250 @@
+package mutations
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+type ListDiff struct {
+	Removed []string
+	Added   []string
+}
+
+func (ld ListDiff) IsEmpty() bool {
+	return len(ld.Removed) == 0 && len(ld.Added) == 0
+}
+
+type StatementDiff struct {
+	// Either -1 for a removed statement, 1 for an added one, or 0 when it is in both.
+	Op        int
+	Statement MutationStatement
+}
+
+type MutationDiff struct {
+	Name string
+	// Old is nil when the mutation was added, New is nil when it was removed.
+	Old *Mutation
+	New *Mutation
+
+	Needs     ListDiff
+	MetaNeeds ListDiff
+	Roles     ListDiff
+	Sql       []StatementDiff
+	Meta      []StatementDiff
+}
+
+func (md *MutationDiff) IsAdded() bool {
+	return md.Old == nil
+}
+
+func (md *MutationDiff) IsRemoved() bool {
+	return md.New == nil
+}
+
+func (md *MutationDiff) SqlChanged() bool {
+	return md.IsAdded() || md.IsRemoved() || md.Old.SqlHash() != md.New.SqlHash()
+}
+
+func (md *MutationDiff) MetaChanged() bool {
+	return md.IsAdded() || md.IsRemoved() || md.Old.MetaHash() != md.New.MetaHash()
+}
+
+func (md *MutationDiff) IsEmpty() bool {
+	return !md.IsAdded() && !md.IsRemoved() && md.Needs.IsEmpty() && md.MetaNeeds.IsEmpty() && md.Roles.IsEmpty() && !md.SqlChanged() && !md.MetaChanged()
+}
+
+func diffNeeds(name string, old []string, new []string) ListDiff {
+	return diffLists(ExplicitNeeds(name, old), ExplicitNeeds(name, new))
+}
+
+func diffLists(old []string, new []string) ListDiff {
+	var res ListDiff
+	for _, o := range old {
+		if !slices.Contains(new, o) {
+			res.Removed = append(res.Removed, o)
+		}
+	}
+	for _, n := range new {
+		if !slices.Contains(old, n) {
+			res.Added = append(res.Added, n)
+		}
+	}
+	return res
+}
+
+func normalizedStatement(stmt MutationStatement) string {
+	up, _ := NormalizeStatement(stmt.Up)
+	down, _ := NormalizeStatement(stmt.Down)
+	return up + "\x00" + down
+}
+
+// diffStatements aligns both lists of statements on their longest common subsequence,
+// comparing them the same way they are hashed.
+func diffStatements(old []MutationStatement, new []MutationStatement) []StatementDiff {
+	var (
+		m, n     = len(old), len(new)
+		old_norm = make([]string, m)
+		new_norm = make([]string, n)
+	)
+	for i, stmt := range old {
+		old_norm[i] = normalizedStatement(stmt)
+	}
+	for j, stmt := range new {
+		new_norm[j] = normalizedStatement(stmt)
+	}
+
+	// lcs[i][j] = length of the common subsequence of old[i:] and new[j:]
+	lcs := make([][]int, m+1)
+	for i := range lcs {
+		lcs[i] = make([]int, n+1)
+	}
+	for i := m - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			if old_norm[i] == new_norm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var res []StatementDiff
+	i, j := 0, 0
+	for i < m || j < n {
+		switch {
+		case i < m && j < n && old_norm[i] == new_norm[j]:
+			res = append(res, StatementDiff{Op: 0, Statement: new[j]})
+			i++
+			j++
+		case i < m && (j == n || lcs[i+1][j] >= lcs[i][j+1]):
+			res = append(res, StatementDiff{Op: -1, Statement: old[i]})
+			i++
+		default:
+			res = append(res, StatementDiff{Op: 1, Statement: new[j]})
+			j++
+		}
+	}
+	return res
+}
+
+func hasChanges(diffs []StatementDiff) bool {
+	for _, d := range diffs {
+		if d.Op != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffSets lists the mutations that differ between old and new, sorted by name.
+func DiffSets(old *MutationSet, new *MutationSet) []*MutationDiff {
+	var names []string
+	for mut := range old.AllMutations() {
+		names = append(names, mut.Name)
+	}
+	for mut := range new.AllMutations() {
+		if !slices.Contains(names, mut.Name) {
+			names = append(names, mut.Name)
+		}
+	}
+	slices.Sort(names)
+
+	var res []*MutationDiff
+	for _, name := range names {
+		var (
+			md                                   = &MutationDiff{Name: name}
+			old_needs, old_meta_needs, old_roles []string
+			new_needs, new_meta_needs, new_roles []string
+			old_sql, old_meta, new_sql, new_meta []MutationStatement
+		)
+		if mut, ok := old.GetMutation(name); ok {
+			md.Old = mut
+			old_needs, old_meta_needs, old_roles, old_sql, old_meta = mut.Needs, mut.MetaNeeds, mut.Roles, mut.Sql, mut.Meta
+		}
+		if mut, ok := new.GetMutation(name); ok {
+			md.New = mut
+			new_needs, new_meta_needs, new_roles, new_sql, new_meta = mut.Needs, mut.MetaNeeds, mut.Roles, mut.Sql, mut.Meta
+		}
+
+		md.Needs = diffNeeds(name, old_needs, new_needs)
+		md.MetaNeeds = diffNeeds(name, old_meta_needs, new_meta_needs)
+		md.Roles = diffLists(old_roles, new_roles)
+		if md.SqlChanged() {
+			md.Sql = diffStatements(old_sql, new_sql)
+		}
+		if md.MetaChanged() {
+			md.Meta = diffStatements(old_meta, new_meta)
+		}
+
+		if !md.IsEmpty() {
+			res = append(res, md)
+		}
+	}
+	return res
+}
+
+func (md *MutationDiff) DisplayName() string {
+	var mut = md.New
+	var sign = au.BrightYellow("~").String()
+	if md.IsAdded() {
+		sign = ITER_SQL_UP.UpOrDown()
+	} else if md.IsRemoved() {
+		mut = md.Old
+		sign = ITER_SQL_DOWN.UpOrDown()
+	}
+	return fmt.Sprintf("%s %s", sign, mut.DisplayName())
+}
+
+func indent(str string, prefix string) string {
+	lines := strings.Split(strings.TrimRight(str, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
+}
+
+func printListDiff(w io.Writer, key string, ld ListDiff) {
+	if ld.IsEmpty() {
+		return
+	}
+	fmt.Fprintf(w, "  %s:", key)
+	for _, removed := range ld.Removed {
+		fmt.Fprintf(w, " %s", au.BrightRed("-"+removed))
+	}
+	for _, added := range ld.Added {
+		fmt.Fprintf(w, " %s", au.BrightGreen("+"+added))
+	}
+	fmt.Fprintln(w)
+}
+
+func printStatementsDiff(w io.Writer, dir IterationDirection, diffs []StatementDiff) {
+	if !hasChanges(diffs) {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", dir.MetaOrSql())
+	for _, d := range diffs {
+		var color = au.Faint
+		var sign = " "
+		switch d.Op {
+		case -1:
+			color, sign = au.BrightRed, "-"
+		case 1:
+			color, sign = au.BrightGreen, "+"
+		}
+		fmt.Fprintln(w, color(indent(d.Statement.Up, "    "+sign+" ")))
+		if d.Op != 0 && d.Statement.Down != "" {
+			fmt.Fprintln(w, color(indent(d.Statement.Down, "    "+sign+" ↓ ")))
+		}
+	}
+}
+
+// Print writes a colorized description of the differences.
+func (md *MutationDiff) Print(w io.Writer) {
+	fmt.Fprintln(w, md.DisplayName())
+	printListDiff(w, "needs", md.Needs)
+	printListDiff(w, "meta_needs", md.MetaNeeds)
+	printListDiff(w, "roles", md.Roles)
+	printStatementsDiff(w, ITER_SQL, md.Sql)
+	printStatementsDiff(w, ITER_META, md.Meta)
+}
@@ -19,11 +19,10 @@ type DigestBuffer struct {
 	bytes.Buffer
 }
 
-// AddStatement Adds an SQL statement to the buffer for later hash computing
-// and does so by "simplifying" it, ignoring white space where convenient, but
-// not inside strings or string like constructs like $$ ... $$
-func (dg *DigestBuffer) AddStatement(stmt string) error {
-	// var reader = strings.NewReader(stmt)
+// NormalizeStatement "simplifies" an SQL statement, ignoring white space and comments where
+// convenient, but not inside strings or string like constructs like $$ ... $$
+func NormalizeStatement(stmt string) (string, error) {
+	var res strings.Builder
 
 	// not checking err since "normally" only code previously lexed
 	// is analyzed here
@@ -31,11 +30,22 @@ func (dg *DigestBuffer) AddStatement(stmt string) error {
 	for tk, err := lx.Next(); !tk.EOF(); tk, err = lx.Next() {
 		if err != nil {
 			log.Print(stmt)
-			return err
+			return "", err
 		}
-		_, _ = dg.WriteString(tk.String())
-		_ = dg.WriteByte(' ')
+		_, _ = res.WriteString(tk.String())
+		_ = res.WriteByte(' ')
+	}
+	return res.String(), nil
+}
+
+// AddStatement Adds an SQL statement to the buffer for later hash computing
+// once normalized by NormalizeStatement.
+func (dg *DigestBuffer) AddStatement(stmt string) error {
+	normalized, err := NormalizeStatement(stmt)
+	if err != nil {
+		return err
 	}
+	_, _ = dg.WriteString(normalized)
 	return nil
 }
 
@@ -0,0 +1,72 @@
+package mutations
+
+import (
+	"context"
+
+	"github.com/samber/oops"
+)
+
+// HASH_VERSION is the version of the way statements are normalized and hashed. It has to be bumped whenever
+// DigestBuffer or the lexer change what a hash is, so that the hashes stored in databases get recomputed
+// with dmut rehash instead of downing every mutation.
+const HASH_VERSION = 1
+
+// storeHashes computes the hashes that are saved along with the mutation.
+func (mut *Mutation) storeHashes() {
+	mut.StoredSqlHash = mut.computeHash(mut.Sql)
+	mut.StoredMetaHash = mut.computeHash(mut.Meta)
+	mut.HashVersion = HASH_VERSION
+}
+
+// CheckHashes refuses a set read from the database whose stored hashes are not the ones this dmut computes
+// from their statements, since comparing it with the local mutations would down them.
+func (ms *MutationSet) CheckHashes() error {
+	var outdated, newer, changed []string
+	for mut := range ms.AllMutations() {
+		// saved before the hashes were stored
+		if mut.StoredSqlHash == "" && mut.StoredMetaHash == "" {
+			continue
+		}
+		switch {
+		case mut.HashVersion > HASH_VERSION:
+			newer = append(newer, mut.Name)
+		case mut.HashVersion < HASH_VERSION:
+			outdated = append(outdated, mut.Name)
+		case mut.StoredSqlHash != mut.computeHash(mut.Sql) || mut.StoredMetaHash != mut.computeHash(mut.Meta):
+			changed = append(changed, mut.Name)
+		}
+	}
+
+	oo := oops.In("hashes").With("namespace", ms.Namespace).With("hash version", HASH_VERSION)
+	if len(newer) > 0 {
+		return oo.With("mutations", newer).Hint("upgrade dmut").
+			Errorf("the hashes of %d mutations of namespace %s were computed by a more recent dmut", len(newer), ms.Namespace)
+	}
+	if len(outdated) > 0 {
+		return oo.With("mutations", outdated).Hint("run dmut rehash to recompute them").
+			Errorf("the hashes of %d mutations of namespace %s were computed by a previous dmut", len(outdated), ms.Namespace)
+	}
+	if len(changed) > 0 {
+		return oo.With("mutations", changed).Hint("the way statements are hashed changed, run dmut rehash if the mutations did not").
+			Errorf("the stored hashes of %d mutations of namespace %s do not match their statements", len(changed), ms.Namespace)
+	}
+	return nil
+}
+
+// Rehash stores the hashes of the mutations of the set as this dmut computes them, and returns the mutations
+// whose hashes changed. A transaction should be started before calling this function.
+func (r *PgRunner) Rehash(ctx context.Context, set *MutationSet) ([]*Mutation, error) {
+	var changed []*Mutation
+	sql := `UPDATE __dmut__.mutations SET sql_hash = $3, meta_hash = $4, hash_version = $5 WHERE namespace = $1 AND name = $2`
+	for _, mut := range set.SortedMutations() {
+		var sql_hash, meta_hash = mut.StoredSqlHash, mut.StoredMetaHash
+		mut.storeHashes()
+		if sql_hash != "" && (sql_hash != mut.StoredSqlHash || meta_hash != mut.StoredMetaHash) {
+			changed = append(changed, mut)
+		}
+		if err := r.exec(ctx, mut, sql, set.Namespace, mut.Name, mut.StoredSqlHash, mut.StoredMetaHash, mut.HashVersion); err != nil {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,37 @@
+package mutations
+
+import "testing"
+
+// Stored hashes are only trusted when they are the ones this dmut computes from the statements.
+func TestCheckHashes(t *testing.T) {
+	set := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+`)
+	mut, _ := set.GetMutation("auth")
+	if err := set.CheckHashes(); err != nil {
+		t.Errorf("mutations saved without hashes should pass, got %v", err)
+	}
+
+	mut.storeHashes()
+	if err := set.CheckHashes(); err != nil {
+		t.Errorf("expected the stored hashes to match, got %v", err)
+	}
+
+	mut.StoredSqlHash = "changed"
+	if err := set.CheckHashes(); err == nil {
+		t.Errorf("expected an error when the stored hash does not match the statements")
+	}
+	if mut.SqlHash() != "changed" {
+		t.Errorf("expected the stored hash to be used")
+	}
+
+	mut.HashVersion = HASH_VERSION - 1
+	if err := set.CheckHashes(); err == nil {
+		t.Errorf("expected an error for hashes of a previous version")
+	}
+	if mut.SqlHash() == "changed" {
+		t.Errorf("expected the hash of a previous version to be recomputed")
+	}
+}
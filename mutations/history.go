@@ -0,0 +1,203 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	au "github.com/logrusorgru/aurora"
+)
+
+// Version is the version of dmut recorded in the history, set by the command line.
+var Version = "dev"
+
+// History is the record of an apply of a namespace, kept in __dmut__.history.
+type History struct {
+	Id           int64
+	Namespace    string
+	FromRevision int
+	ToRevision   int
+	Override     bool
+	Version      string
+	OsUser       string
+	DbUser       string
+	Hostname     string
+	Ts           time.Time
+	Runs         []*HistoryRun
+}
+
+// HistoryRun is a runnable that was run by an apply.
+type HistoryRun struct {
+	Mutation    string
+	Direction   IterationDirection
+	Transaction bool
+	Statements  int
+	Duration    time.Duration
+}
+
+// NewHistory starts the record of an apply by this process.
+func NewHistory(namespace string, from_revision int, to_revision int) *History {
+	var os_user = os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		os_user = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return &History{
+		Namespace:    namespace,
+		FromRevision: from_revision,
+		ToRevision:   to_revision,
+		Version:      Version,
+		OsUser:       os_user,
+		Hostname:     hostname,
+	}
+}
+
+func newHistoryRun(runnable *Runnable, duration time.Duration) *HistoryRun {
+	var statements = 0
+	for _, stmt := range runnable.Statements() {
+		if stmt != "" {
+			statements++
+		}
+	}
+	return &HistoryRun{
+		Mutation:    runnable.Mutation.Name,
+		Direction:   runnable.Direction,
+		Transaction: runnable.InTransaction(),
+		Statements:  statements,
+		Duration:    duration,
+	}
+}
+
+// historyRecorder is an Executor that adds the runnables it runs to a history.
+type historyRecorder struct {
+	Executor
+	history *History
+}
+
+func (h *historyRecorder) Run(ctx context.Context, runnable *Runnable) error {
+	if runnable.IsEmpty() {
+		return nil
+	}
+	start := time.Now()
+	if err := h.Executor.Run(ctx, runnable); err != nil {
+		return err
+	}
+	h.history.Runs = append(h.history.Runs, newHistoryRun(runnable, time.Since(start)))
+	return nil
+}
+
+// SaveHistory records an apply along with the runnables it ran.
+func (r *PgRunner) SaveHistory(ctx context.Context, history *History) error {
+	sql := `INSERT INTO __dmut__.history(namespace, from_revision, to_revision, override, dmut_version, os_user, hostname)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, db_user, ts`
+	if err := r.conn.QueryRow(ctx, sql,
+		history.Namespace, history.FromRevision, history.ToRevision, history.Override, history.Version, history.OsUser, history.Hostname,
+	).Scan(&history.Id, &history.DbUser, &history.Ts); err != nil {
+		return wrapPgError(err, sql)
+	}
+	return r.AppendHistory(ctx, history.Namespace, history.Runs...)
+}
+
+// AppendHistory adds runnables to the last apply of the namespace, such as the ones that ran after the commit.
+func (r *PgRunner) AppendHistory(ctx context.Context, namespace string, runs ...*HistoryRun) error {
+	sql := `INSERT INTO __dmut__.history_runnables(history_id, mutation, down, meta, transaction, statements, duration)
+		VALUES ((SELECT max(id) FROM __dmut__.history WHERE namespace = $1), $2, $3, $4, $5, $6, $7)`
+	for _, run := range runs {
+		if err := r.exec(ctx, nil, sql, namespace, run.Mutation, run.Direction.Down, run.Direction.Meta, run.Transaction, run.Statements, run.Duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHistory returns the applies recorded in the database, the most recent first, optionally only those of a namespace.
+func (r *PgRunner) GetHistory(ctx context.Context, namespace string) ([]*History, error) {
+	var exists bool
+	sql := `SELECT to_regclass('__dmut__.history') IS NOT NULL`
+	if err := r.conn.QueryRow(ctx, sql).Scan(&exists); err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	sql = `SELECT id, namespace, from_revision, to_revision, override, dmut_version, os_user, db_user, hostname, ts
+		FROM __dmut__.history
+		WHERE $1 = '' OR namespace = $1
+		ORDER BY id DESC`
+	rows, err := r.conn.Query(ctx, sql, namespace)
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	res, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*History, error) {
+		var h History
+		err := row.Scan(&h.Id, &h.Namespace, &h.FromRevision, &h.ToRevision, &h.Override, &h.Version, &h.OsUser, &h.DbUser, &h.Hostname, &h.Ts)
+		return &h, err
+	})
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+
+	var by_id = make(map[int64]*History, len(res))
+	for _, h := range res {
+		by_id[h.Id] = h
+	}
+
+	sql = `SELECT history_id, mutation, down, meta, transaction, statements, duration
+		FROM __dmut__.history_runnables
+		WHERE $1 = '' OR history_id IN (SELECT id FROM __dmut__.history WHERE namespace = $1)
+		ORDER BY id`
+	rows, err = r.conn.Query(ctx, sql, namespace)
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var run HistoryRun
+		if err := rows.Scan(&id, &run.Mutation, &run.Direction.Down, &run.Direction.Meta, &run.Transaction, &run.Statements, &run.Duration); err != nil {
+			return nil, wrapPgError(err, sql)
+		}
+		if h, ok := by_id[id]; ok {
+			h.Runs = append(h.Runs, &run)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+
+	return res, nil
+}
+
+// RanMutation tells if the apply ran a mutation whose name matches the glob pattern.
+func (h *History) RanMutation(pattern string) bool {
+	return slices.ContainsFunc(h.Runs, func(run *HistoryRun) bool {
+		matched, _ := path.Match(pattern, run.Mutation)
+		return matched
+	})
+}
+
+// Print writes the apply and the runnables it ran.
+func (h *History) Print(w io.Writer) {
+	var override = ""
+	if h.Override {
+		override = au.BrightYellow("override").String()
+	}
+	fmt.Fprintln(w, au.Faint(fmt.Sprintf("#%d", h.Id)), h.Ts.Local().Format("2006-01-02 15:04:05"),
+		au.BrightMagenta(h.Namespace), au.BrightGreen(fmt.Sprintf("%d → %d", h.FromRevision, h.ToRevision)),
+		"by", au.BrightBlue(h.OsUser), fmt.Sprintf("(%s)", h.DbUser), "on", h.Hostname, "dmut", h.Version, override)
+	for _, run := range h.Runs {
+		var after_commit = ""
+		if !run.Transaction {
+			after_commit = au.BrightYellow("after commit").String()
+		}
+		fmt.Fprintln(w, "   ", run.Direction.UpOrDown(), run.Mutation+"·"+run.Direction.MetaOrSql(),
+			au.Faint(fmt.Sprintf("%d statements in %s", run.Statements, run.Duration.Round(time.Millisecond))), after_commit)
+	}
+}
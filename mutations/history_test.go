@@ -0,0 +1,27 @@
+package mutations
+
+import (
+	"testing"
+	"time"
+)
+
+// The empty downs are not counted as statements, and applies are found by the mutations they ran.
+func TestHistoryRun(t *testing.T) {
+	local := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+    - up: create extension if not exists pgcrypto;
+      down: ""
+`)
+	mut, _ := local.GetMutation("auth")
+	if run := newHistoryRun(mut.Runnable(ITER_SQL_DOWN), time.Second); run.Statements != 1 || !run.Transaction {
+		t.Errorf("expected a single statement in the transaction, got %d", run.Statements)
+	}
+
+	history := NewHistory("", 0, 1)
+	history.Runs = append(history.Runs, newHistoryRun(mut.Runnable(ITER_SQL_UP), time.Second))
+	if !history.RanMutation("au*") || history.RanMutation("api.*") {
+		t.Errorf("expected the apply to have run auth only")
+	}
+}
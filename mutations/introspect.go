@@ -0,0 +1,529 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	au "github.com/logrusorgru/aurora"
+)
+
+// Introspection of an existing database into mutations, so that databases that never used dmut can be adopted.
+// Every schema, extension, table, sequence, type, function and view becomes a mutation. Indexes and foreign keys are
+// child mutations of their table. Grants, policies, triggers and row level security go in the meta of the object they are on.
+// needs are inferred from pg_depend.
+
+type catalogRef struct {
+	classid uint32
+	objid   uint32
+}
+
+type introspected struct {
+	name  string
+	meta  bool // whether stmts go in the meta block
+	stmts []MutationStatement
+	// grants, policies, triggers..., always in meta
+	meta_stmts []MutationStatement
+
+	needs      []string
+	meta_needs []string
+}
+
+// part of a sub object on its owner
+type introspectedPart struct {
+	owner *introspected
+	meta  bool
+}
+
+type introspection struct {
+	runner  *PgRunner
+	schemas []string
+
+	entries []*introspected
+	names   map[string]bool
+	objects map[catalogRef]*introspected
+	// sub objects, like defaults, constraints or rules, that are part of an entry
+	parts map[catalogRef]introspectedPart
+}
+
+// missingDown is the down of the statements whose down could not be inferred, it has to be written by hand.
+const missingDown = "-- TODO: dmut could not infer the down of this statement, write it"
+
+// introspectedStatement uses the automatic down of the statement when there is one.
+func introspectedStatement(up string, down string) MutationStatement {
+	if auto, err := AutoDowner.ParseAndGetDefault(up); err == nil && auto != "" {
+		down = auto
+	}
+	if down == "" {
+		down = missingDown
+	}
+	return MutationStatement{Up: up, Down: down}
+}
+
+func (in *introspection) add(classid uint32, objid uint32, name string, meta bool, stmt MutationStatement) *introspected {
+	var unique_name = name
+	for i := 2; in.names[unique_name]; i++ {
+		unique_name = fmt.Sprintf("%s_%d", name, i)
+	}
+	in.names[unique_name] = true
+
+	entry := &introspected{name: unique_name, meta: meta, stmts: []MutationStatement{stmt}}
+	in.entries = append(in.entries, entry)
+	in.objects[catalogRef{classid, objid}] = entry
+	return entry
+}
+
+// query runs a catalog query. The queries that use sql_user_schema take the list of schemas to introspect as $1.
+func (in *introspection) query(ctx context.Context, sql string, scan func(rows pgx.Rows) error, args ...any) error {
+	rows, err := in.runner.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return wrapPgError(err, sql)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return wrapPgError(err, sql)
+		}
+	}
+	return wrapPgError(rows.Err(), sql)
+}
+
+const sql_user_schema = `n.nspname not in ('pg_catalog', 'information_schema', '__dmut__', 'dmut')
+	and n.nspname not like 'pg\_%'
+	and (cardinality($1::text[]) = 0 or n.nspname = any($1::text[]))`
+
+func sqlNotInExtension(catalog string, oid string) string {
+	return fmt.Sprintf(`not exists (
+		select 1 from pg_catalog.pg_depend e
+		where e.classid = '%s'::regclass and e.objid = %s and e.deptype = 'e'
+	)`, catalog, oid)
+}
+
+const sql_grantee = `case when a.grantee = 0 then 'public' else quote_ident(pg_catalog.pg_get_userbyid(a.grantee)) end`
+
+var introspection_queries = []struct {
+	kind string
+	sql  string
+}{
+	{"schemas", `
+		select 'pg_namespace'::regclass::oid, n.oid, n.nspname, format('create schema %I;', n.nspname)
+		from pg_catalog.pg_namespace n
+		where n.nspname <> 'public' and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_namespace", "n.oid") + `
+		order by n.nspname`},
+
+	{"extensions", `
+		select 'pg_extension'::regclass::oid, x.oid, x.extname, format('create extension %I with schema %I;', x.extname, n.nspname)
+		from pg_catalog.pg_extension x
+		join pg_catalog.pg_namespace n on n.oid = x.extnamespace
+		where x.extname <> 'plpgsql' and ` + sql_user_schema + `
+		order by x.extname`},
+
+	{"sequences", `
+		select 'pg_class'::regclass::oid, c.oid, n.nspname || '.' || c.relname,
+			format('create sequence %I.%I as %s increment by %s minvalue %s maxvalue %s start with %s%s;',
+				n.nspname, c.relname, format_type(s.seqtypid, null), s.seqincrement, s.seqmin, s.seqmax, s.seqstart,
+				case when s.seqcycle then ' cycle' else '' end)
+		from pg_catalog.pg_sequence s
+		join pg_catalog.pg_class c on c.oid = s.seqrelid
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "c.oid") + `
+			-- identity columns own their sequence
+			and not exists (select 1 from pg_catalog.pg_depend d where d.classid = 'pg_class'::regclass and d.objid = c.oid and d.deptype = 'i')
+		order by 3`},
+
+	{"types", `
+		select 'pg_type'::regclass::oid, t.oid, n.nspname || '.' || t.typname,
+			case t.typtype
+			when 'e' then format('create type %I.%I as enum (%s);', n.nspname, t.typname,
+				(select string_agg(quote_literal(e.enumlabel), ', ' order by e.enumsortorder) from pg_catalog.pg_enum e where e.enumtypid = t.oid))
+			when 'c' then format('create type %I.%I as (%s);', n.nspname, t.typname,
+				(select string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod)), ', ' order by a.attnum)
+				from pg_catalog.pg_attribute a where a.attrelid = t.typrelid and a.attnum > 0 and not a.attisdropped))
+			else format('create domain %I.%I as %s%s%s%s;', n.nspname, t.typname, format_type(t.typbasetype, t.typtypmod),
+				coalesce(' default ' || t.typdefault, ''),
+				case when t.typnotnull then ' not null' else '' end,
+				coalesce((select string_agg(format(' constraint %I %s', co.conname, pg_get_constraintdef(co.oid)), '' order by co.conname)
+				from pg_catalog.pg_constraint co where co.contypid = t.oid and co.contype = 'c'), ''))
+			end
+		from pg_catalog.pg_type t
+		join pg_catalog.pg_namespace n on n.oid = t.typnamespace
+		where ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_type", "t.oid") + `
+			and (t.typtype in ('e', 'd') or t.typtype = 'c' and (select c.relkind from pg_catalog.pg_class c where c.oid = t.typrelid) = 'c')
+		order by 3`},
+
+	{"tables", `
+		select 'pg_class'::regclass::oid, c.oid, n.nspname || '.' || c.relname,
+			format(E'create %stable %I.%I (\n  %s\n)%s;',
+				case c.relpersistence when 'u' then 'unlogged ' else '' end,
+				n.nspname, c.relname,
+				(select string_agg(def, E',\n  ' order by ord, def) from (
+					select a.attnum as ord, format('%I %s%s%s%s', a.attname, format_type(a.atttypid, a.atttypmod),
+						case a.attidentity when 'a' then ' generated always as identity' when 'd' then ' generated by default as identity' else '' end,
+						case when a.attgenerated = 's' then format(' generated always as (%s) stored', pg_get_expr(ad.adbin, ad.adrelid))
+							when ad.adbin is not null then ' default ' || pg_get_expr(ad.adbin, ad.adrelid)
+							else '' end,
+						case when a.attnotnull then ' not null' else '' end) as def
+					from pg_catalog.pg_attribute a
+					left join pg_catalog.pg_attrdef ad on ad.adrelid = a.attrelid and ad.adnum = a.attnum
+					where a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped
+					union all
+					select 32767, format('constraint %I %s', co.conname, pg_get_constraintdef(co.oid))
+					from pg_catalog.pg_constraint co
+					where co.conrelid = c.oid and co.contype in ('p', 'u', 'c', 'x') and co.conislocal
+				) defs),
+				case when c.relkind = 'p' then ' partition by ' || pg_get_partkeydef(c.oid) else '' end)
+		from pg_catalog.pg_class c
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where c.relkind in ('r', 'p') and not c.relispartition and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "c.oid") + `
+		order by 3`},
+
+	{"foreign keys", `
+		select 'pg_constraint'::regclass::oid, co.oid, n.nspname || '.' || c.relname || '.' || co.conname,
+			format('alter table %I.%I add constraint %I %s;', n.nspname, c.relname, co.conname, pg_get_constraintdef(co.oid))
+		from pg_catalog.pg_constraint co
+		join pg_catalog.pg_class c on c.oid = co.conrelid
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where co.contype = 'f' and co.conislocal and not c.relispartition and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "c.oid") + `
+		order by 3`},
+
+	{"indexes", `
+		select 'pg_class'::regclass::oid, i.indexrelid, n.nspname || '.' || t.relname || '.' || ic.relname, pg_get_indexdef(i.indexrelid) || ';'
+		from pg_catalog.pg_index i
+		join pg_catalog.pg_class ic on ic.oid = i.indexrelid
+		join pg_catalog.pg_class t on t.oid = i.indrelid
+		join pg_catalog.pg_namespace n on n.oid = t.relnamespace
+		where t.relkind in ('r', 'p') and not t.relispartition and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "t.oid") + `
+			and not exists (select 1 from pg_catalog.pg_constraint co where co.conindid = i.indexrelid and co.contype in ('p', 'u', 'x'))
+		order by 3`},
+
+	{"functions", `
+		select 'pg_proc'::regclass::oid, p.oid, n.nspname || '.' || p.proname, pg_get_functiondef(p.oid) || ';'
+		from pg_catalog.pg_proc p
+		join pg_catalog.pg_namespace n on n.oid = p.pronamespace
+		where p.prokind in ('f', 'p') and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_proc", "p.oid") + `
+		order by 3, p.oid`},
+
+	{"views", `
+		select 'pg_class'::regclass::oid, c.oid, n.nspname || '.' || c.relname,
+			format(E'create %sview %I.%I as\n%s', case when c.relkind = 'm' then 'materialized ' else '' end, n.nspname, c.relname, pg_get_viewdef(c.oid, true))
+		from pg_catalog.pg_class c
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where c.relkind in ('v', 'm') and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "c.oid") + `
+		order by 3`},
+}
+
+// statements that go in the meta of the object they are on, with their classid, objid, up and down
+var introspection_meta_queries = []struct {
+	kind string
+	sql  string
+}{
+	{"row level security", `
+		select 'pg_class'::regclass::oid, c.oid, s.up, s.down
+		from pg_catalog.pg_class c
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		cross join lateral (values
+			(c.relrowsecurity, format('alter table %I.%I enable row level security;', n.nspname, c.relname), format('alter table %I.%I disable row level security;', n.nspname, c.relname)),
+			(c.relforcerowsecurity, format('alter table %I.%I force row level security;', n.nspname, c.relname), format('alter table %I.%I no force row level security;', n.nspname, c.relname))
+		) s(enabled, up, down)
+		where s.enabled and c.relkind in ('r', 'p') and ` + sql_user_schema + `
+		order by c.oid`},
+
+	{"materialized view indexes", `
+		select 'pg_class'::regclass::oid, t.oid, pg_get_indexdef(i.indexrelid) || ';', ''
+		from pg_catalog.pg_index i
+		join pg_catalog.pg_class t on t.oid = i.indrelid
+		join pg_catalog.pg_namespace n on n.oid = t.relnamespace
+		where t.relkind = 'm' and ` + sql_user_schema + `
+		order by i.indexrelid`},
+
+	{"schema grants", `
+		select 'pg_namespace'::regclass::oid, n.oid,
+			format('grant %s on schema %I to %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type), n.nspname, ` + sql_grantee + `),
+			format('revoke %s on schema %I from %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type), n.nspname, ` + sql_grantee + `)
+		from pg_catalog.pg_namespace n, aclexplode(n.nspacl) a
+		where a.grantee <> n.nspowner and n.nspname <> 'public' and ` + sql_user_schema + `
+		group by n.oid, n.nspname, a.grantee
+		order by n.oid, 3`},
+
+	{"relation grants", `
+		select 'pg_class'::regclass::oid, c.oid,
+			format('grant %s on %s %I.%I to %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type),
+				case c.relkind when 'S' then 'sequence' else 'table' end, n.nspname, c.relname, ` + sql_grantee + `),
+			format('revoke %s on %s %I.%I from %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type),
+				case c.relkind when 'S' then 'sequence' else 'table' end, n.nspname, c.relname, ` + sql_grantee + `)
+		from pg_catalog.pg_class c
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace, aclexplode(c.relacl) a
+		where a.grantee <> c.relowner and c.relkind in ('r', 'p', 'v', 'm', 'S') and ` + sql_user_schema + `
+		group by c.oid, c.relkind, n.nspname, c.relname, a.grantee
+		order by c.oid, 3`},
+
+	{"function grants", `
+		select 'pg_proc'::regclass::oid, p.oid,
+			format('grant %s on %s %I.%I(%s) to %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type),
+				case p.prokind when 'p' then 'procedure' else 'function' end, n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), ` + sql_grantee + `),
+			format('revoke %s on %s %I.%I(%s) from %s;', string_agg(a.privilege_type, ', ' order by a.privilege_type),
+				case p.prokind when 'p' then 'procedure' else 'function' end, n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), ` + sql_grantee + `)
+		from pg_catalog.pg_proc p
+		join pg_catalog.pg_namespace n on n.oid = p.pronamespace, aclexplode(p.proacl) a
+		where a.grantee <> p.proowner and p.prokind in ('f', 'p') and ` + sql_user_schema + `
+		group by p.oid, p.prokind, n.nspname, p.proname, a.grantee
+		order by p.oid, 3`},
+
+	{"policies", `
+		select 'pg_class'::regclass::oid, pol.polrelid,
+			format('create policy %I on %I.%I%s for %s%s%s%s;', pol.polname, n.nspname, c.relname,
+				case when not pol.polpermissive then ' as restrictive' else '' end,
+				case pol.polcmd when 'r' then 'select' when 'a' then 'insert' when 'w' then 'update' when 'd' then 'delete' else 'all' end,
+				case when pol.polroles = '{0}' then '' else ' to ' || (select string_agg(quote_ident(r.rolname), ', ' order by r.rolname) from pg_catalog.pg_roles r where r.oid = any(pol.polroles)) end,
+				coalesce(' using (' || pg_get_expr(pol.polqual, pol.polrelid) || ')', ''),
+				coalesce(' with check (' || pg_get_expr(pol.polwithcheck, pol.polrelid) || ')', '')),
+			format('drop policy %I on %I.%I;', pol.polname, n.nspname, c.relname)
+		from pg_catalog.pg_policy pol
+		join pg_catalog.pg_class c on c.oid = pol.polrelid
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where ` + sql_user_schema + `
+		order by pol.polrelid, pol.polname`},
+
+	{"triggers", `
+		select 'pg_class'::regclass::oid, tg.tgrelid, pg_get_triggerdef(tg.oid, true) || ';', format('drop trigger %I on %I.%I;', tg.tgname, n.nspname, c.relname)
+		from pg_catalog.pg_trigger tg
+		join pg_catalog.pg_class c on c.oid = tg.tgrelid
+		join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+		where not tg.tgisinternal and ` + sql_user_schema + `
+		order by tg.tgrelid, tg.tgname`},
+}
+
+// sub objects, with the catalog and oid of what they are part of and whether they are part of its meta
+const sql_introspection_parts = `
+	select 'pg_attrdef'::regclass::oid, oid, 'pg_class'::regclass::oid, adrelid, false from pg_catalog.pg_attrdef
+	union all
+	select 'pg_constraint'::regclass::oid, oid, 'pg_class'::regclass::oid, conrelid, false from pg_catalog.pg_constraint where conrelid <> 0
+	union all
+	select 'pg_constraint'::regclass::oid, oid, 'pg_type'::regclass::oid, contypid, false from pg_catalog.pg_constraint where contypid <> 0
+	union all
+	select 'pg_rewrite'::regclass::oid, oid, 'pg_class'::regclass::oid, ev_class, false from pg_catalog.pg_rewrite
+	union all
+	select 'pg_trigger'::regclass::oid, oid, 'pg_class'::regclass::oid, tgrelid, true from pg_catalog.pg_trigger
+	union all
+	select 'pg_policy'::regclass::oid, oid, 'pg_class'::regclass::oid, polrelid, true from pg_catalog.pg_policy
+	union all
+	select 'pg_type'::regclass::oid, oid, 'pg_class'::regclass::oid, typrelid, false from pg_catalog.pg_type where typrelid <> 0
+	union all
+	select 'pg_type'::regclass::oid, oid, 'pg_type'::regclass::oid, typelem, false from pg_catalog.pg_type where typelem <> 0 and typcategory = 'A'
+	union all
+	select 'pg_class'::regclass::oid, indexrelid, 'pg_class'::regclass::oid, indrelid, false from pg_catalog.pg_index
+`
+
+// only user objects, whose oids start at FirstNormalObjectId
+const sql_introspection_depends = `
+	select classid, objid, refclassid, refobjid
+	from pg_catalog.pg_depend
+	where deptype = 'n' and objid >= 16384 and refobjid >= 16384
+`
+
+func (in *introspection) resolve(ref catalogRef) (entry *introspected, meta bool, ok bool) {
+	if entry, ok := in.objects[ref]; ok {
+		return entry, entry.meta, true
+	}
+	if part, ok := in.parts[ref]; ok {
+		return part.owner, part.meta || part.owner.meta, true
+	}
+	return nil, false, false
+}
+
+type catalogPart struct {
+	ref   catalogRef
+	of    catalogRef
+	meta  bool
+	found bool
+}
+
+func (in *introspection) readParts(ctx context.Context) error {
+	var parts []*catalogPart
+	if err := in.query(ctx, sql_introspection_parts, func(rows pgx.Rows) error {
+		var p catalogPart
+		if err := rows.Scan(&p.ref.classid, &p.ref.objid, &p.of.classid, &p.of.objid, &p.meta); err != nil {
+			return err
+		}
+		parts = append(parts, &p)
+		return nil
+	}); err != nil {
+		return err
+	}
+	in.resolveParts(parts)
+	return nil
+}
+
+func (in *introspection) resolveParts(parts []*catalogPart) {
+	// parts can be parts of parts, like the array type of a table row type
+	for changed := true; changed; {
+		changed = false
+		for _, p := range parts {
+			if p.found {
+				continue
+			}
+			if _, ok := in.objects[p.ref]; ok {
+				// indexes that have their own mutation are not parts of their table
+				p.found = true
+				continue
+			}
+			if owner, meta, ok := in.resolve(p.of); ok {
+				in.parts[p.ref] = introspectedPart{owner: owner, meta: p.meta || meta}
+				p.found = true
+				changed = true
+			}
+		}
+	}
+}
+
+type catalogDependency struct {
+	ref catalogRef
+	on  catalogRef
+}
+
+func (in *introspection) readDependencies(ctx context.Context) error {
+	var deps []catalogDependency
+	if err := in.query(ctx, sql_introspection_depends, func(rows pgx.Rows) error {
+		var d catalogDependency
+		if err := rows.Scan(&d.ref.classid, &d.ref.objid, &d.on.classid, &d.on.objid); err != nil {
+			return err
+		}
+		deps = append(deps, d)
+		return nil
+	}); err != nil {
+		return err
+	}
+	in.resolveDependencies(deps)
+	return nil
+}
+
+func (in *introspection) resolveDependencies(deps []catalogDependency) {
+	// sql runs before all meta, so meta objects that sql depends on have to be moved to sql
+	for changed := true; changed; {
+		changed = false
+		for _, d := range deps {
+			entry, meta, ok := in.resolve(d.ref)
+			on, on_meta, on_ok := in.resolve(d.on)
+			if ok && on_ok && entry != on && !meta && on_meta && on.meta {
+				on.meta = false
+				changed = true
+			}
+		}
+	}
+
+	for _, d := range deps {
+		entry, meta, ok := in.resolve(d.ref)
+		on, on_meta, on_ok := in.resolve(d.on)
+		if !ok || !on_ok || entry == on {
+			continue
+		}
+		if !meta && !on_meta && !slices.Contains(entry.needs, on.name) {
+			entry.needs = append(entry.needs, on.name)
+		}
+		if meta && on_meta && !slices.Contains(entry.meta_needs, on.name) {
+			entry.meta_needs = append(entry.meta_needs, on.name)
+		}
+	}
+}
+
+func newIntrospection(r *PgRunner, schemas []string) *introspection {
+	if schemas == nil {
+		schemas = []string{}
+	}
+	return &introspection{
+		runner:  r,
+		schemas: schemas,
+		names:   make(map[string]bool),
+		objects: make(map[catalogRef]*introspected),
+		parts:   make(map[catalogRef]introspectedPart),
+	}
+}
+
+// Introspect reads the catalog of the database into a mutation set. When schemas is empty, all
+// the schemas that are not internal to postgres or dmut are read.
+func (r *PgRunner) Introspect(ctx context.Context, namespace string, schemas []string) (*MutationSet, error) {
+	in := newIntrospection(r, schemas)
+
+	for _, q := range introspection_queries {
+		var count = 0
+		var meta = q.kind == "functions" || q.kind == "views"
+		if err := in.query(ctx, q.sql, func(rows pgx.Rows) error {
+			var classid, objid uint32
+			var name, up string
+			if err := rows.Scan(&classid, &objid, &name, &up); err != nil {
+				return err
+			}
+			in.add(classid, objid, name, meta, introspectedStatement(up, ""))
+			count++
+			return nil
+		}, in.schemas); err != nil {
+			return nil, err
+		}
+		r.logger.Println(au.BrightGreen("→"), "read", count, q.kind)
+	}
+
+	for _, q := range introspection_meta_queries {
+		if err := in.query(ctx, q.sql, func(rows pgx.Rows) error {
+			var classid, objid uint32
+			var up, down string
+			if err := rows.Scan(&classid, &objid, &up, &down); err != nil {
+				return err
+			}
+			if entry, ok := in.objects[catalogRef{classid, objid}]; ok {
+				entry.meta_stmts = append(entry.meta_stmts, introspectedStatement(up, down))
+			}
+			return nil
+		}, in.schemas); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := in.readParts(ctx); err != nil {
+		return nil, err
+	}
+	if err := in.readDependencies(ctx); err != nil {
+		return nil, err
+	}
+
+	return in.mutationSet(namespace, r.logger)
+}
+
+// mutationSet turns the introspected entries into mutations, warning on the logger about what has to be fixed by hand.
+func (in *introspection) mutationSet(namespace string, logger *log.Logger) (*MutationSet, error) {
+	res := NewMutationSet(namespace, 0, "")
+	for _, entry := range in.entries {
+		mut := &Mutation{
+			Name:      entry.name,
+			Needs:     entry.needs,
+			MetaNeeds: entry.meta_needs,
+		}
+		if entry.meta {
+			mut.Meta = append(entry.stmts, entry.meta_stmts...)
+		} else {
+			mut.Sql = entry.stmts
+			mut.Meta = entry.meta_stmts
+		}
+		if err := res.AddMutation(mut); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []string
+	for mut := range res.AllMutations() {
+		if slices.ContainsFunc(slices.Concat(mut.Sql, mut.Meta), func(stmt MutationStatement) bool { return stmt.Down == missingDown }) {
+			missing = append(missing, mut.Name)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		logger.Println(au.BrightYellow("warning"), len(missing), "introspected mutations have statements whose down has to be written by hand:", missing)
+	}
+
+	if err := res.ResolveDependencies(); err != nil {
+		logger.Println(au.BrightYellow("warning"), "the introspected mutations cannot be applied as is:", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package mutations
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Only the queries that filter on the schemas take them as $1, pgx refuses arguments a query does not use.
+func TestIntrospectionQueryArguments(t *testing.T) {
+	for _, q := range slices.Concat(introspection_queries, introspection_meta_queries) {
+		if !strings.Contains(q.sql, "$1") {
+			t.Errorf("the %s query is given the schemas but does not use them", q.kind)
+		}
+	}
+	for _, sql := range []string{sql_introspection_parts, sql_introspection_depends} {
+		if strings.Contains(sql, "$1") {
+			t.Errorf("expected no arguments in %s", sql)
+		}
+	}
+}
+
+// Parts belong to the entry they are on, and dependencies become needs. A function that a default
+// depends on is moved from the meta to the sql.
+func TestIntrospectionNeeds(t *testing.T) {
+	const (
+		pg_namespace = 2615
+		pg_class     = 1259
+		pg_proc      = 1255
+		pg_attrdef   = 2604
+		pg_trigger   = 2620
+	)
+	in := newIntrospection(nil, nil)
+	in.add(pg_namespace, 1, "api", false, introspectedStatement("create schema api;", ""))
+	in.add(pg_class, 2, "api.users", false, introspectedStatement("create table api.users (id int);", ""))
+	in.add(pg_proc, 3, "api.next_id", true, introspectedStatement("create function api.next_id() returns int language sql as $$ select 1 $$;", ""))
+	in.add(pg_proc, 4, "api.touch", true, introspectedStatement("create function api.touch() returns trigger language plpgsql as $$ begin return new; end $$;", ""))
+
+	in.resolveParts([]*catalogPart{
+		{ref: catalogRef{pg_attrdef, 10}, of: catalogRef{pg_class, 2}},
+		{ref: catalogRef{pg_trigger, 11}, of: catalogRef{pg_class, 2}, meta: true},
+	})
+	in.resolveDependencies([]catalogDependency{
+		{ref: catalogRef{pg_class, 2}, on: catalogRef{pg_namespace, 1}},
+		{ref: catalogRef{pg_attrdef, 10}, on: catalogRef{pg_proc, 3}},
+		{ref: catalogRef{pg_trigger, 11}, on: catalogRef{pg_proc, 4}},
+		{ref: catalogRef{pg_proc, 4}, on: catalogRef{pg_namespace, 1}},
+	})
+
+	set, err := in.mutationSet("", log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, _ := set.GetMutation("api.users")
+	if !slices.Contains(users.Needs, "api") || !slices.Contains(users.Needs, "api.next_id") {
+		t.Errorf("expected api.users to need api and api.next_id, got %v", users.Needs)
+	}
+	if !slices.Contains(users.MetaNeeds, "api.touch") {
+		t.Errorf("expected the trigger of api.users to need api.touch, got %v", users.MetaNeeds)
+	}
+
+	next_id, _ := set.GetMutation("api.next_id")
+	if len(next_id.Sql) != 1 || len(next_id.Meta) != 0 {
+		t.Errorf("expected api.next_id to be moved to sql, got %v and %v", next_id.Sql, next_id.Meta)
+	}
+	touch, _ := set.GetMutation("api.touch")
+	if len(touch.Meta) != 1 || len(touch.Sql) != 0 {
+		t.Errorf("expected api.touch to stay in meta, got %v and %v", touch.Sql, touch.Meta)
+	}
+}
+
+// A dependency cycle is only warned about, the mutations are still written so that they can be fixed by hand.
+func TestIntrospectionCycle(t *testing.T) {
+	const pg_class = 1259
+	in := newIntrospection(nil, nil)
+	in.add(pg_class, 1, "a", true, introspectedStatement("create view a as select 1;", ""))
+	in.add(pg_class, 2, "b", true, introspectedStatement("create view b as select 1;", ""))
+	in.resolveDependencies([]catalogDependency{
+		{ref: catalogRef{pg_class, 1}, on: catalogRef{pg_class, 2}},
+		{ref: catalogRef{pg_class, 2}, on: catalogRef{pg_class, 1}},
+	})
+
+	var buf bytes.Buffer
+	set, err := in.mutationSet("", log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Size() != 2 {
+		t.Errorf("expected both views to be kept, got %d mutations", set.Size())
+	}
+	if !strings.Contains(buf.String(), "cannot be applied as is") {
+		t.Errorf("expected a warning about the cycle, got %q", buf.String())
+	}
+}
+
+// A statement whose down cannot be inferred gets a placeholder down, and is warned about.
+func TestIntrospectionMissingDown(t *testing.T) {
+	const pg_class = 1259
+	in := newIntrospection(nil, nil)
+	in.add(pg_class, 1, "users", false, introspectedStatement("create table users (id int);", ""))
+	entry := in.add(pg_class, 2, "orders", false, introspectedStatement("create table orders (id int);", ""))
+	entry.stmts = append(entry.stmts, introspectedStatement("comment on table orders is 'orders';", ""))
+
+	var buf bytes.Buffer
+	set, err := in.mutationSet("", log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, _ := set.GetMutation("orders")
+	if orders.Sql[1].Down != missingDown {
+		t.Errorf("expected a placeholder down, got %q", orders.Sql[1].Down)
+	}
+	if !strings.Contains(buf.String(), "[orders]") {
+		t.Errorf("expected a warning naming orders, got %q", buf.String())
+	}
+}
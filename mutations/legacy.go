@@ -0,0 +1,126 @@
+package mutations
+
+import (
+	"context"
+	"strings"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+// Support for the dmut.mutations table of the versions of dmut prior to 1.0.0
+
+type legacyMutation struct {
+	Name     string
+	Needs    []string
+	Up       []string
+	Down     []string
+	Children []string
+}
+
+// Replace line comments that start with --, keep the comment but put it between /* and */
+func replaceLineComments(str string) string {
+	lines := strings.Split(str, "\n")
+	for i, line := range lines {
+		if idx := strings.Index(line, "--"); idx >= 0 {
+			comment := line[idx+2:]
+			lines[i] = line[:idx] + "/* " + comment + " */"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (mut *legacyMutation) statements() []MutationStatement {
+	var res []MutationStatement
+
+	if len(mut.Up) != len(mut.Down) {
+		for _, up := range mut.Up {
+			res = append(res, MutationStatement{Up: replaceLineComments(up)})
+		}
+		// add down in reverse order
+		for i := len(mut.Down) - 1; i >= 0; i-- {
+			res = append(res, MutationStatement{Down: replaceLineComments(mut.Down[i])})
+		}
+		return res
+	}
+
+	for i, up := range mut.Up {
+		up = replaceLineComments(up)
+		down := replaceLineComments(mut.Down[len(mut.Down)-i-1])
+
+		// if we can undo it, the automatic down is used so that the statement is written as a plain string
+		if auto, err := AutoDowner.ParseAndGetDefault(up); err == nil && auto != "" {
+			down = auto
+		}
+		res = append(res, MutationStatement{Up: up, Down: down})
+	}
+	return res
+}
+
+// HasLegacySchema tells if the database holds the dmut schema of the versions prior to 1.0.0
+func (r *PgRunner) HasLegacySchema(ctx context.Context) (bool, error) {
+	var exists bool
+	sql := `select to_regclass('dmut.mutations') is not null`
+	if err := r.conn.QueryRow(ctx, sql).Scan(&exists); err != nil {
+		return false, wrapPgError(err, sql)
+	}
+	return exists, nil
+}
+
+// GetLegacyMutations reads the mutations recorded by a version of dmut prior to 1.0.0 into a set.
+func (r *PgRunner) GetLegacyMutations(ctx context.Context, namespace string) (*MutationSet, error) {
+	sql := `SELECT name, up, down, children FROM dmut.mutations ORDER BY name`
+	rows, err := r.conn.Query(ctx, sql)
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	defer rows.Close()
+
+	var (
+		mut_map = make(map[string]*legacyMutation)
+		names   []string
+	)
+	for rows.Next() {
+		var mut legacyMutation
+		if err := rows.Scan(&mut.Name, &mut.Up, &mut.Down, &mut.Children); err != nil {
+			return nil, wrapPgError(err, sql)
+		}
+		if mut.Name != "dmut.base" && mut.Name != "dmut" {
+			mut_map[mut.Name] = &mut
+			names = append(names, mut.Name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+
+	for _, name := range names {
+		mut := mut_map[name]
+		for _, child := range mut.Children {
+			child_mut, ok := mut_map[child]
+			if !ok {
+				r.logger.Println(au.BrightYellow("warning"), "mutation", au.BrightBlue(name), "has a child", au.BrightBlue(child), "that is not in dmut.mutations")
+				continue
+			}
+			child_mut.Needs = append(child_mut.Needs, mut.Name)
+		}
+	}
+
+	res := NewMutationSet(namespace, 0, "")
+	for _, name := range names {
+		mut := mut_map[name]
+		if err := res.AddMutation(&Mutation{
+			Name:  mut.Name,
+			Needs: mut.Needs,
+			Sql:   mut.statements(),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// DropLegacySchema removes the dmut schema of the versions prior to 1.0.0
+func (r *PgRunner) DropLegacySchema(ctx context.Context) error {
+	r.logger.Println(au.BrightRed("↓"), "dropping the legacy dmut schema")
+	return r.exec(ctx, nil, `DROP SCHEMA dmut CASCADE`)
+}
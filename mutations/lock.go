@@ -0,0 +1,118 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
+// Advisory locks are scoped to the current database, and keyed on the namespace. Since every apply
+// also locks dmut's own namespace to bootstrap the tracking table, applies to a database are serialized.
+const lockKeys = `hashtext('__dmut__'), hashtext($1)`
+
+// lockPollInterval is how often the lock is tried again when waiting with a timeout.
+var lockPollInterval = 500 * time.Millisecond
+
+// lockHolder describes the backend that holds the lock of a namespace, or returns "" if nobody does.
+func (r *PgRunner) lockHolder(ctx context.Context, namespace string) (string, error) {
+	var (
+		pid                                    int
+		user, application, client, query_start string
+	)
+	sql := `select
+			a.pid,
+			coalesce(a.usename, ''),
+			coalesce(a.application_name, ''),
+			coalesce(host(a.client_addr), 'local'),
+			coalesce(to_char(a.xact_start, 'YYYY-MM-DD HH24:MI:SS'), '')
+		from pg_catalog.pg_locks l
+			join pg_catalog.pg_stat_activity a on a.pid = l.pid
+		where l.locktype = 'advisory'
+			and l.granted
+			and l.database = (select oid from pg_catalog.pg_database where datname = current_database())
+			and l.classid = hashtext('__dmut__')::oid
+			and l.objid = hashtext($1)::oid
+			and l.objsubid = 2
+			and l.pid <> pg_backend_pid()
+		limit 1`
+	rows, err := r.conn.Query(ctx, sql, namespace)
+	if err != nil {
+		return "", wrapPgError(err, sql)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", wrapPgError(rows.Err(), sql)
+	}
+	if err := rows.Scan(&pid, &user, &application, &client, &query_start); err != nil {
+		return "", wrapPgError(err, sql)
+	}
+	return fmt.Sprintf("pid %d, user %s, application '%s' from %s, in a transaction since %s", pid, user, application, client, query_start), nil
+}
+
+// Lock takes the session-level advisory lock of a namespace, so that two dmut do not apply it at the same time.
+// It waits forever when timeout is 0. The lock is held until Unlock, even if the transaction is rolled back.
+func (r *PgRunner) Lock(ctx context.Context, namespace string, timeout time.Duration) error {
+	var acquired bool
+	try_sql := `select pg_catalog.pg_try_advisory_lock(` + lockKeys + `)`
+	if err := r.conn.QueryRow(ctx, try_sql, namespace).Scan(&acquired); err != nil {
+		return wrapPgError(err, try_sql)
+	}
+
+	if !acquired {
+		holder, err := r.lockHolder(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		r.logger.Println(au.BrightYellow("⏳"), "waiting for the lock of namespace", au.BrightMagenta(namespace).String(), "held by", holder)
+
+		if timeout == 0 {
+			if err := r.exec(ctx, nil, `select pg_catalog.pg_advisory_lock(`+lockKeys+`)`, namespace); err != nil {
+				return err
+			}
+			acquired = true
+		}
+
+		var deadline = time.Now().Add(timeout)
+		for !acquired && time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(min(lockPollInterval, time.Until(deadline))):
+			}
+			if err := r.conn.QueryRow(ctx, try_sql, namespace).Scan(&acquired); err != nil {
+				return wrapPgError(err, try_sql)
+			}
+		}
+
+		if !acquired {
+			if holder, err = r.lockHolder(ctx, namespace); err != nil {
+				return err
+			}
+			return oops.In("lock").
+				With("namespace", namespace).
+				With("holder", holder).
+				Errorf("could not lock namespace %s after %s, another dmut is applying it", namespace, timeout)
+		}
+	}
+
+	r.locks = append(r.locks, namespace)
+	return nil
+}
+
+// Unlock releases all the locks taken by Lock. It should be called once the transaction was committed or rolled back.
+// Like Rollback, it is not cancelled with the context.
+func (r *PgRunner) Unlock(ctx context.Context) error {
+	ctx = context.WithoutCancel(ctx)
+	sql := `select pg_catalog.pg_advisory_unlock(` + lockKeys + `)`
+	for len(r.locks) > 0 {
+		namespace := r.locks[len(r.locks)-1]
+		if err := r.exec(ctx, nil, sql, namespace); err != nil {
+			return oops.With("namespace", namespace).Wrap(err)
+		}
+		r.locks = r.locks[:len(r.locks)-1]
+	}
+	return nil
+}
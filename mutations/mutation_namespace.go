@@ -2,6 +2,7 @@ package mutations
 
 import (
 	"math"
+	"slices"
 
 	"github.com/samber/oops"
 	"github.com/ugurcsen/gods-generic/maps/linkedhashmap"
@@ -108,3 +109,57 @@ func (ns MutationNamespace) ResolveDependencies() error {
 	}
 	return nil
 }
+
+// RevisionsToApply returns the sets that have to be applied, in order, on a database at db_revision.
+// When the database has no revision, only the highest one is applied, unless all is set.
+func (rs *RevisionSequence) RevisionsToApply(db_revision int, all bool) []*MutationSet {
+	var res []*MutationSet
+
+	if db_revision == 0 {
+		if !all {
+			if revision, ok := rs.Revisions[rs.MaxRevision]; ok {
+				res = append(res, revision)
+			}
+			return res
+		}
+		// db revision cannot be less than 1
+		db_revision = 1
+	}
+
+	for i := db_revision; i <= rs.MaxRevision; i++ {
+		if revision, ok := rs.Revisions[i]; ok {
+			res = append(res, revision)
+		}
+	}
+	return res
+}
+
+// Namespaces returns the namespaces in the order they are applied. dmut's own, which is only there when the
+// mutations were read from a database, comes first as the others are saved in it.
+func (ns *MutationNamespace) Namespaces() []string {
+	var res []string
+	if _, ok := ns.Map.Get(DMUT_NAMESPACE); ok {
+		res = append(res, DMUT_NAMESPACE)
+	}
+	for _, namespace := range ns.Keys() {
+		if namespace != DMUT_NAMESPACE {
+			res = append(res, namespace)
+		}
+	}
+	return res
+}
+
+// KeepNamespaces removes all the namespaces but the given ones and dmut's own.
+func (ns *MutationNamespace) KeepNamespaces(namespaces []string) error {
+	for _, namespace := range namespaces {
+		if _, ok := ns.Map.Get(namespace); !ok {
+			return oops.In("mutations").With("namespace", namespace).With("namespaces", ns.Keys()).Errorf("namespace %s was not found", namespace)
+		}
+	}
+	for _, namespace := range ns.Keys() {
+		if namespace != DMUT_NAMESPACE && !slices.Contains(namespaces, namespace) {
+			ns.DeleteNamespace(namespace)
+		}
+	}
+	return nil
+}
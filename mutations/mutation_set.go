@@ -2,6 +2,7 @@ package mutations
 
 import (
 	"iter"
+	"slices"
 	"strings"
 
 	"github.com/samber/oops"
@@ -17,6 +18,9 @@ type MutationSet struct {
 	Revision     int
 	File         string
 	HasOverrides bool // has NewSql or NewNeeds
+
+	// Variables the statements are rendered with when read from yaml
+	vars map[string]any
 }
 
 func (ms *MutationSet) AsNewMutationSet() *MutationSet {
@@ -28,6 +32,24 @@ func (ms *MutationSet) AsNewMutationSet() *MutationSet {
 	return ms2
 }
 
+// filter returns a copy of the set with only the mutations that keep returns true for.
+func (ms *MutationSet) filter(keep func(mut *Mutation) bool) (*MutationSet, error) {
+	res := NewMutationSet(ms.Namespace, ms.Revision, ms.File)
+	res.vars = ms.vars
+	for mut := range ms.AllMutations() {
+		if !keep(mut) {
+			continue
+		}
+		if err := res.AddMutation(mut.clone()); err != nil {
+			return nil, err
+		}
+	}
+	if err := res.ResolveDependencies(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (ms *MutationSet) GetMutation(name string) (*Mutation, bool) {
 	if ms == nil || ms.Map == nil {
 		return nil, false
@@ -51,6 +73,20 @@ func (ms *MutationSet) AllMutations() iter.Seq[*Mutation] {
 	}
 }
 
+// Roles lists, sorted, the roles declared by the mutations of the set.
+func (ms *MutationSet) Roles() []string {
+	var res []string
+	for mut := range ms.AllMutations() {
+		for _, role := range mut.Roles {
+			if !slices.Contains(res, role) {
+				res = append(res, role)
+			}
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
 func (mcm MutationChildrenMap) AddChild(parent_name string, child *Mutation) {
 	if _, ok := mcm[parent_name]; !ok {
 		mcm[parent_name] = []*Mutation{child}
@@ -93,7 +129,8 @@ func (ms *MutationSet) AddMutation(mut *Mutation) error {
 	mut.Namespace = ms.Namespace
 	ms.Map.Put(mut.Name, mut)
 
-	if len(mut.NewSql) > 0 || len(mut.NewNeeds) > 0 {
+	// an empty new_sql retires the mutation, it is an override all the same
+	if mut.NewSql != nil || mut.NewNeeds != nil {
 		ms.HasOverrides = true
 	}
 
@@ -174,6 +211,23 @@ func (ms *MutationSet) ResolveDependencies() error {
 		}
 	}
 
+	// the sql of mutations outside of the transaction runs last, nothing in the transaction may need it
+	for mut := range ms.AllMutations() {
+		if !mut.NoTransaction {
+			continue
+		}
+		if len(mut.Meta) > 0 {
+			return oops.In("mutations").With("mutation", mut.Name).Errorf("%s runs outside of the transaction and cannot have meta", mut.Name)
+		}
+		if children := mut.SqlChildren.Values(); len(children) > 0 {
+			var names []string
+			for _, child := range children {
+				names = append(names, child.Name)
+			}
+			return oops.In("mutations").With("mutation", mut.Name).With("needed by", names).Errorf("%s runs outside of the transaction and cannot be needed by other mutations", mut.Name)
+		}
+	}
+
 	return nil
 }
 
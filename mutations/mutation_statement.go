@@ -1,6 +1,8 @@
 package mutations
 
 import (
+	"strings"
+
 	"github.com/goccy/go-yaml"
 	"github.com/goccy/go-yaml/ast"
 	"github.com/samber/oops"
@@ -11,11 +13,11 @@ type MutationStatement struct {
 	Down string `json:"down" yaml:"down"`
 }
 
-func parseStatements(value ast.Node) (list []MutationStatement, err error) {
+func parseStatements(ms *MutationSet, value ast.Node) (list []MutationStatement, err error) {
 	list = []MutationStatement{}
 	if seq, ok := value.(*ast.SequenceNode); ok {
 		for _, node := range seq.Values {
-			stmt, err := parseSingleStatement(node)
+			stmt, err := parseSingleStatement(ms, node)
 			if err != nil {
 				return list, err
 			}
@@ -28,13 +30,35 @@ func parseStatements(value ast.Node) (list []MutationStatement, err error) {
 	return list, oops.In("mutations").Errorf("expected sequence, got %T", value)
 }
 
-func parseSingleStatement(value ast.Node) (stmt MutationStatement, err error) {
+// render runs a statement through the templates, before it is hashed or its down is inferred.
+func (ms *MutationSet) render(stmt string) (string, error) {
+	res, err := RunTemplate(stmt, NewTemplateContext(ms.Namespace, ms.Revision, ms.vars))
+	if err != nil {
+		oo := oops.In("mutations").With("statement", stmt).With("file", ms.File)
+		if strings.Contains(err.Error(), "map has no entry for key") {
+			oo = oo.Hint("give the variables with --env, --vars or --var")
+		}
+		return "", oo.Wrapf(err, "error rendering statement")
+	}
+	return res, nil
+}
+
+func parseSingleStatement(ms *MutationSet, value ast.Node) (stmt MutationStatement, err error) {
 	var single string
 	if err := yaml.NodeToValue(value, &single); err == nil {
+		if single, err = ms.render(single); err != nil {
+			return stmt, err
+		}
 		return mutationStatementFromString(single)
 	}
 
 	if err := yaml.NodeToValue(value, &stmt); err == nil {
+		if stmt.Up, err = ms.render(stmt.Up); err != nil {
+			return stmt, err
+		}
+		if stmt.Down, err = ms.render(stmt.Down); err != nil {
+			return stmt, err
+		}
 		return stmt, nil
 	}
 
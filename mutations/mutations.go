@@ -1,8 +1,10 @@
 package mutations
 
 import (
+	"context"
 	"iter"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/goccy/go-yaml/ast"
@@ -12,8 +14,8 @@ import (
 )
 
 type IterationDirection struct {
-	Down bool
-	Meta bool
+	Down bool `json:"down"`
+	Meta bool `json:"meta"`
 }
 
 func (dir IterationDirection) UpOrDown() string {
@@ -53,6 +55,25 @@ type Mutation struct {
 	MetaNeeds []string            `json:"meta_needs,omitempty"`
 	Meta      []MutationStatement `json:"meta,omitempty"`
 
+	// Roles that must exist before any sql runs
+	Roles []string `json:"roles,omitempty"`
+
+	// Whether apply may down the sql of this mutation without being told to
+	AllowDown bool `json:"-"`
+
+	// statement_timeout of the statements of this mutation, overriding --statement-timeout
+	Timeout time.Duration `json:"-"`
+
+	// Whether the sql of this mutation runs outside of the transaction, once it was committed
+	NoTransaction bool `json:"-"`
+	// Whether the sql of this mutation was recorded but not run yet, or failed, outside of the transaction
+	Pending bool `json:"pending,omitempty"`
+
+	// Hashes as stored in the database, and the version of the algorithm that computed them
+	StoredSqlHash  string `json:"sql_hash,omitempty"`
+	StoredMetaHash string `json:"meta_hash,omitempty"`
+	HashVersion    int    `json:"hash_version,omitempty"`
+
 	//
 	NewNeeds []string            `json:"new_needs"`
 	NewSql   []MutationStatement `json:"new_sql"`
@@ -72,7 +93,7 @@ func (mut *Mutation) NameComponents() []string {
 }
 
 func (mut *Mutation) ShouldBeSaved() bool {
-	return len(mut.NewSql) > 0 || len(mut.NewNeeds) > 0 || len(mut.Sql) > 0 || len(mut.Needs) > 0 || len(mut.Meta) > 0 || len(mut.MetaNeeds) > 0
+	return mut.NewSql != nil || mut.NewNeeds != nil || len(mut.Sql) > 0 || len(mut.Needs) > 0 || len(mut.Meta) > 0 || len(mut.MetaNeeds) > 0 || len(mut.Roles) > 0
 }
 
 func parseStringList(value ast.Node) (list []string, err error) {
@@ -109,13 +130,13 @@ func parseMutation(name string, ms *MutationSet, value ast.Node) (mut *Mutation,
 				mut.Needs = list
 			}
 		case "sql":
-			if list, err := parseStatements(value); err != nil {
+			if list, err := parseStatements(ms, value); err != nil {
 				return nil, err
 			} else {
 				mut.Sql = list
 			}
 		case "meta":
-			if list, err := parseStatements(value); err != nil {
+			if list, err := parseStatements(ms, value); err != nil {
 				return nil, err
 			} else {
 				mut.Meta = list
@@ -126,6 +147,18 @@ func parseMutation(name string, ms *MutationSet, value ast.Node) (mut *Mutation,
 			} else {
 				mut.MetaNeeds = list
 			}
+		case "roles":
+			list, err := parseStringList(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, role := range list {
+				// roles are rendered as well, as they usually share a prefix with the roles of the statements
+				if role, err = ms.render(role); err != nil {
+					return nil, err
+				}
+				mut.Roles = append(mut.Roles, role)
+			}
 		case "new_needs":
 			if list, err := parseStringList(value); err != nil {
 				return nil, err
@@ -133,11 +166,29 @@ func parseMutation(name string, ms *MutationSet, value ast.Node) (mut *Mutation,
 				mut.NewNeeds = list
 			}
 		case "new_sql":
-			if list, err := parseStatements(value); err != nil {
+			if list, err := parseStatements(ms, value); err != nil {
 				return nil, err
 			} else {
 				mut.NewSql = list
 			}
+		case "allow_down":
+			if err := yaml.NodeToValue(value, &mut.AllowDown); err != nil {
+				return nil, oo.Wrapf(err, "error decoding allow_down %T", value)
+			}
+		case "timeout":
+			var timeout string
+			if err := yaml.NodeToValue(value, &timeout); err != nil {
+				return nil, oo.Wrapf(err, "error decoding timeout %T", value)
+			}
+			if mut.Timeout, err = time.ParseDuration(timeout); err != nil {
+				return nil, oo.Wrapf(err, "invalid timeout '%s'", timeout)
+			}
+		case "transaction":
+			var transaction bool
+			if err := yaml.NodeToValue(value, &transaction); err != nil {
+				return nil, oo.Wrapf(err, "error decoding transaction %T", value)
+			}
+			mut.NoTransaction = !transaction
 		case "children":
 			children_def, ok := value.(*ast.MappingNode)
 			if !ok {
@@ -175,6 +226,11 @@ func (mut *Mutation) AsNewMutation() *Mutation {
 		Sql:       mut.Sql,
 		MetaNeeds: mut.MetaNeeds,
 		Meta:      mut.Meta,
+		Roles:     mut.Roles,
+		AllowDown: mut.AllowDown,
+		Timeout:   mut.Timeout,
+
+		NoTransaction: mut.NoTransaction,
 	}
 
 	if mut.NewSql != nil {
@@ -193,17 +249,25 @@ func (mut *Mutation) DisplayName() string {
 	return au.BrightMagenta(mut.set.Namespace).String() + " " + mut.Name
 }
 
+// SqlHash returns the stored hash of the sql when it was computed by this version of the algorithm.
 func (mut *Mutation) SqlHash() string {
-	digest := NewDigestBuffer()
-	digest.WriteString(mut.Name)
-	digest.AddStatements(mut.Sql...)
-	return digest.Digest()
+	if mut.StoredSqlHash != "" && mut.HashVersion == HASH_VERSION {
+		return mut.StoredSqlHash
+	}
+	return mut.computeHash(mut.Sql)
 }
 
 func (mut *Mutation) MetaHash() string {
+	if mut.StoredMetaHash != "" && mut.HashVersion == HASH_VERSION {
+		return mut.StoredMetaHash
+	}
+	return mut.computeHash(mut.Meta)
+}
+
+func (mut *Mutation) computeHash(stmts []MutationStatement) string {
 	digest := NewDigestBuffer()
 	digest.WriteString(mut.Name)
-	digest.AddStatements(mut.Meta...)
+	digest.AddStatements(stmts...)
 	return digest.Digest()
 }
 
@@ -263,9 +327,9 @@ func (mut *Mutation) Runnable(dir IterationDirection) *Runnable {
 	}
 }
 
-func (mut *Mutation) RunRecursively(runner Executor, dir IterationDirection) error {
+func (mut *Mutation) RunRecursively(ctx context.Context, runner Executor, dir IterationDirection) error {
 	for dep := range mut.IterateDependencies(dir) {
-		if err := runner.Run(dep.Runnable(dir)); err != nil {
+		if err := runner.Run(ctx, dep.Runnable(dir)); err != nil {
 			return err
 		}
 	}
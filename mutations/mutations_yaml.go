@@ -18,8 +18,61 @@ var dmut_mutations embed.FS
 
 type MutationMap map[string]*Mutation
 
-func (ms *MutationSet) readFile(system fs.FS, filename string) error {
-	ms.File = filename
+// readDocument reads a single yaml document of a mutation file into the set.
+func (ms *MutationSet) readDocument(filename string, node ast.Node) error {
+	map_node, ok := node.(*ast.MappingNode)
+	if !ok {
+		return oops.In("mutations").With("filename", filename).Errorf("expected a mapping node, got %T", node)
+	}
+
+	// the header is read first, as the statements are rendered with the namespace and revision
+	var mutation_values []*ast.MappingValueNode
+	for _, mapping := range map_node.Values {
+		key_node := mapping.Key
+		var key string
+		if err := yaml.NodeToValue(key_node, &key); err != nil {
+			return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding key %T", key_node)
+		}
+		value := mapping.Value
+
+		switch key {
+		case "__namespace":
+			var namespace string
+			if err := yaml.NodeToValue(value, &namespace); err != nil {
+				return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding __namespace %T", value)
+			}
+			ms.Namespace = namespace
+		case "__revision":
+			var revision int
+			if err := yaml.NodeToValue(value, &revision); err != nil {
+				return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding __revision %T", value)
+			}
+			ms.Revision = revision
+		default:
+			mutation_values = append(mutation_values, mapping)
+		}
+	}
+
+	for _, mapping := range mutation_values {
+		var key string
+		if err := yaml.NodeToValue(mapping.Key, &key); err != nil {
+			return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding key %T", mapping.Key)
+		}
+		if _, err := parseMutation(key, ms, mapping.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFile reads a yaml file into the namespace. Every document of the file is its own mutation set,
+// so that a collected file may hold several namespaces and revisions.
+func readFile(namespace *MutationNamespace, system fs.FS, filename string, vars map[string]any) error {
+	if !strings.HasSuffix(filename, ".yaml") && !strings.HasSuffix(filename, ".yml") {
+		return nil
+	}
+
 	f, err := system.Open(filename)
 	if err != nil {
 		return oops.In("mutations").With("filename", filename).Wrapf(err, "error reading file %s", filename)
@@ -28,7 +81,6 @@ func (ms *MutationSet) readFile(system fs.FS, filename string) error {
 
 	dec := yaml.NewDecoder(f)
 	for {
-		// var mp = make(map[string]interface{})
 		var node ast.Node
 
 		err = dec.Decode(&node)
@@ -39,68 +91,28 @@ func (ms *MutationSet) readFile(system fs.FS, filename string) error {
 			return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding file %s", filename)
 		}
 
-		map_node, ok := node.(*ast.MappingNode)
-		if !ok {
-			return oops.In("mutations").With("filename", filename).Errorf("expected a mapping node, got %T", node)
-		}
-
-		for _, mapping := range map_node.Values {
-			key_node := mapping.Key
-			var key string
-			if err := yaml.NodeToValue(key_node, &key); err != nil {
-				return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding key %T", key_node)
-			}
-			value := mapping.Value
-
-			switch key {
-			case "__namespace":
-				var namespace string
-				if err := yaml.NodeToValue(value, &namespace); err != nil {
-					return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding __namespace %T", value)
-				}
-				ms.Namespace = namespace
-			case "__revision":
-				var revision int
-				if err := yaml.NodeToValue(value, &revision); err != nil {
-					return oops.In("mutations").With("filename", filename).Wrapf(err, "error decoding __revision %T", value)
-				}
-				ms.Revision = revision
-			default:
-				if _, err := parseMutation(key, ms, value); err != nil {
-					return err
-				}
-			}
+		ms := NewMutationSet("", 0, filename)
+		ms.vars = vars
+		if err := ms.readDocument(filename, node); err != nil {
+			return err
 		}
-
+		namespace.AddSet(ms)
 	}
 	return nil
 }
 
-func readFile(namespace *MutationNamespace, system fs.FS, filename string) error {
-	if !strings.HasSuffix(filename, ".yaml") && !strings.HasSuffix(filename, ".yml") {
-		return nil
-	}
-
-	ms := NewMutationSet("", 0, filename)
-	if err := ms.readFile(system, filename); err != nil {
-		return err
-	}
-	namespace.AddSet(ms)
-	return nil
-}
-
-func browseFs(namespace *MutationNamespace, system fs.FS, root string) error {
+func browseFs(namespace *MutationNamespace, system fs.FS, root string, vars map[string]any) error {
 	entries, err := fs.ReadDir(system, root)
 	if err != nil {
 		return err
 	}
 	for _, entry := range entries {
 		if entry.IsDir() {
-			if err := browseFs(namespace, system, filepath.Join(root, entry.Name())); err != nil {
+			if err := browseFs(namespace, system, filepath.Join(root, entry.Name()), vars); err != nil {
 				return err
 			}
 		} else {
-			if err := readFile(namespace, system, filepath.Join(root, entry.Name())); err != nil {
+			if err := readFile(namespace, system, filepath.Join(root, entry.Name()), vars); err != nil {
 				return err
 			}
 		}
@@ -109,11 +121,12 @@ func browseFs(namespace *MutationNamespace, system fs.FS, root string) error {
 }
 
 func LoadYamlMutations(paths ...string) (*MutationNamespace, error) {
-	var res = NewMutationNamespace()
+	return LoadYamlMutationsWithVars(nil, paths...)
+}
 
-	if err := browseFs(res, dmut_mutations, "."); err != nil {
-		return nil, err
-	}
+// LoadYamlMutationsWithVars reads the mutations, rendering their statements with the given template variables.
+func LoadYamlMutationsWithVars(vars map[string]any, paths ...string) (*MutationNamespace, error) {
+	var res = NewMutationNamespace()
 
 	for _, path := range paths {
 		if info, err := os.Stat(path); err != nil {
@@ -121,19 +134,28 @@ func LoadYamlMutations(paths ...string) (*MutationNamespace, error) {
 		} else if info.IsDir() {
 
 			dirfs := os.DirFS(path)
-			if err := browseFs(res, dirfs, "."); err != nil {
+			if err := browseFs(res, dirfs, ".", vars); err != nil {
 				return nil, err
 			}
 
 		} else {
 			dirfs := os.DirFS(filepath.Dir(path))
 			fname := filepath.Base(path)
-			if err := readFile(res, dirfs, fname); err != nil {
+			if err := readFile(res, dirfs, fname, vars); err != nil {
 				return nil, err
 			}
 		}
 	}
 
+	// dmut's own mutations are applied by Bootstrap, they cannot be changed by the user files
+	if revisions, ok := res.Map.Get(DMUT_NAMESPACE); ok {
+		var files []string
+		for _, set := range revisions.Revisions {
+			files = append(files, set.File)
+		}
+		return nil, oops.In("mutations").With("files", files).Errorf("the namespace %s is reserved to dmut", DMUT_NAMESPACE)
+	}
+
 	if err := res.ResolveDependencies(); err != nil {
 		return nil, err
 	}
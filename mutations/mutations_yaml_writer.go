@@ -0,0 +1,203 @@
+package mutations
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/samber/oops"
+)
+
+// The namespace of the mutations embedded in dmut itself.
+const DMUT_NAMESPACE = "__dmut__"
+
+type yamlMutation struct {
+	Needs     []string `yaml:"needs,omitempty,flow"`
+	MetaNeeds []string `yaml:"meta_needs,omitempty,flow"`
+	Roles     []string `yaml:"roles,omitempty,flow"`
+	Sql       []any    `yaml:"sql,omitempty"`
+	Meta      []any    `yaml:"meta,omitempty"`
+	AllowDown bool     `yaml:"allow_down,omitempty"`
+	Timeout   string   `yaml:"timeout,omitempty"`
+
+	// Only written when false, as it defaults to true.
+	Transaction *bool `yaml:"transaction,omitempty"`
+
+	// Pointers, since an empty new_sql is not the same as an absent one.
+	NewNeeds *[]string `yaml:"new_needs,omitempty,flow"`
+	NewSql   *[]any    `yaml:"new_sql,omitempty"`
+}
+
+type yamlStatement struct {
+	Up   string `yaml:"up"`
+	Down string `yaml:"down,omitempty"`
+}
+
+// yamlStatements outputs statements as plain strings when their down can be inferred,
+// and as up/down mappings otherwise.
+func yamlStatements(stmts []MutationStatement) []any {
+	var res = make([]any, 0, len(stmts))
+	for _, stmt := range stmts {
+		if down, err := AutoDowner.ParseAndGetDefault(stmt.Up); err == nil && down != "" && down == stmt.Down {
+			res = append(res, stmt.Up)
+		} else {
+			res = append(res, yamlStatement{Up: stmt.Up, Down: stmt.Down})
+		}
+	}
+	return res
+}
+
+// ImplicitParents returns the names of the mutations that a dotted name automatically depends on,
+// whether or not they exist.
+func ImplicitParents(name string) []string {
+	var res []string
+	split_name := strings.Split(name, ".")
+	for i := 0; i < len(split_name)-1; i++ {
+		res = append(res, strings.Join(split_name[:i+1], "."))
+	}
+	return res
+}
+
+// ExplicitNeeds removes the implicit parents that ResolveDependencies adds, as well as duplicates.
+func ExplicitNeeds(name string, needs []string) []string {
+	var res []string
+	implicit := ImplicitParents(name)
+	for _, need := range needs {
+		if slices.Contains(implicit, need) || slices.Contains(res, need) {
+			continue
+		}
+		res = append(res, need)
+	}
+	return res
+}
+
+func (mut *Mutation) asYaml() yamlMutation {
+	res := yamlMutation{
+		Needs:     ExplicitNeeds(mut.Name, mut.Needs),
+		MetaNeeds: ExplicitNeeds(mut.Name, mut.MetaNeeds),
+		Roles:     mut.Roles,
+		Sql:       yamlStatements(mut.Sql),
+		Meta:      yamlStatements(mut.Meta),
+		AllowDown: mut.AllowDown,
+	}
+	if mut.Timeout != 0 {
+		res.Timeout = mut.Timeout.String()
+	}
+	if mut.NoTransaction {
+		var transaction = false
+		res.Transaction = &transaction
+	}
+	if mut.NewNeeds != nil {
+		new_needs := ExplicitNeeds(mut.Name, mut.NewNeeds)
+		if new_needs == nil {
+			new_needs = []string{}
+		}
+		res.NewNeeds = &new_needs
+	}
+	if mut.NewSql != nil {
+		new_sql := yamlStatements(mut.NewSql)
+		res.NewSql = &new_sql
+	}
+	return res
+}
+
+// SortedMutations returns the mutations of the set sorted by name, which puts parents before their children.
+func (ms *MutationSet) SortedMutations() []*Mutation {
+	var res []*Mutation
+	for mut := range ms.AllMutations() {
+		res = append(res, mut)
+	}
+	slices.SortFunc(res, func(a, b *Mutation) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+// SortedRevisions returns the sets of the sequence by ascending revision.
+func (rs *RevisionSequence) SortedRevisions() []*MutationSet {
+	var res []*MutationSet
+	for _, set := range rs.Revisions {
+		res = append(res, set)
+	}
+	slices.SortFunc(res, func(a, b *MutationSet) int {
+		return a.Revision - b.Revision
+	})
+	return res
+}
+
+// AsYaml returns the set as a yaml document, with its __namespace and __revision header.
+// Children are flattened, as they already have their full dotted name.
+func (ms *MutationSet) AsYaml() yaml.MapSlice {
+	var doc = ms.yamlHeader()
+	for _, mut := range ms.SortedMutations() {
+		doc = append(doc, yaml.MapItem{Key: mut.Name, Value: mut.asYaml()})
+	}
+	return doc
+}
+
+func (ms *MutationSet) yamlHeader() yaml.MapSlice {
+	var doc = yaml.MapSlice{}
+	if ms.Namespace != "" {
+		doc = append(doc, yaml.MapItem{Key: "__namespace", Value: ms.Namespace})
+	}
+	if ms.Revision != 0 {
+		doc = append(doc, yaml.MapItem{Key: "__revision", Value: ms.Revision})
+	}
+	return doc
+}
+
+// WriteMutationYaml writes a single mutation of the set as a document of its own, with the header of the set.
+func (ms *MutationSet) WriteMutationYaml(w io.Writer, mut *Mutation) error {
+	if _, err := io.WriteString(w, "# yaml-embedded-languages: sql\n\n"); err != nil {
+		return err
+	}
+	doc := append(ms.yamlHeader(), yaml.MapItem{Key: mut.Name, Value: mut.asYaml()})
+	if err := NewYamlEncoder(w).Encode(doc); err != nil {
+		return oops.In("mutations").With("namespace", ms.Namespace).With("mutation", mut.Name).Wrapf(err, "error encoding mutation")
+	}
+	return nil
+}
+
+func NewYamlEncoder(w io.Writer) *yaml.Encoder {
+	return yaml.NewEncoder(w,
+		yaml.Indent(2),
+		yaml.IndentSequence(true),
+		yaml.UseLiteralStyleIfMultiline(true),
+	)
+}
+
+func (ms *MutationSet) WriteYaml(w io.Writer) error {
+	if _, err := io.WriteString(w, "# yaml-embedded-languages: sql\n\n"); err != nil {
+		return err
+	}
+	if err := NewYamlEncoder(w).Encode(ms.AsYaml()); err != nil {
+		return oops.In("mutations").With("namespace", ms.Namespace).With("revision", ms.Revision).Wrapf(err, "error encoding mutations")
+	}
+	return nil
+}
+
+// WriteYaml writes every namespace and revision as a stream of yaml documents, sorted so that the
+// output only changes when the mutations do. The __dmut__ namespace is left out since it is always embedded.
+func (ns *MutationNamespace) WriteYaml(w io.Writer) error {
+	if _, err := io.WriteString(w, "# yaml-embedded-languages: sql\n\n"); err != nil {
+		return err
+	}
+
+	var names = ns.Keys()
+	slices.Sort(names)
+
+	enc := NewYamlEncoder(w)
+	for _, namespace := range names {
+		if namespace == DMUT_NAMESPACE {
+			continue
+		}
+		revisions, _ := ns.Map.Get(namespace)
+		for _, set := range revisions.SortedRevisions() {
+			if err := enc.Encode(set.AsYaml()); err != nil {
+				return oops.In("mutations").With("namespace", namespace).With("revision", set.Revision).Wrapf(err, "error encoding mutations")
+			}
+		}
+	}
+	return nil
+}
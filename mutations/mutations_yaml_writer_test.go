@@ -0,0 +1,61 @@
+package mutations
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Collecting mutations and reading them back should not change any of them.
+func TestWriteYamlRoundTrip(t *testing.T) {
+	orig, err := LoadYamlMutations("test/test.yml", "../test/revision")
+	if err != nil {
+		t.Fatalf("error loading mutations: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := orig.WriteYaml(&buf); err != nil {
+		t.Fatalf("error writing mutations: %v", err)
+	}
+
+	collected := filepath.Join(t.TempDir(), "collected.yml")
+	if err := os.WriteFile(collected, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := LoadYamlMutations(collected)
+	if err != nil {
+		t.Fatalf("error reading back collected mutations: %v\n%s", err, buf.String())
+	}
+
+	for _, namespace := range orig.Keys() {
+		orig_revisions, _ := orig.Get(namespace)
+		read_revisions, ok := read.Get(namespace)
+		if !ok {
+			t.Fatalf("namespace %s is missing", namespace)
+		}
+		for revision, orig_set := range orig_revisions.Revisions {
+			read_set, ok := read_revisions.Revisions[revision]
+			if !ok {
+				t.Fatalf("revision %d of namespace %s is missing", revision, namespace)
+			}
+			if orig_set.Size() != read_set.Size() {
+				t.Errorf("%s r%d: expected %d mutations, got %d", namespace, revision, orig_set.Size(), read_set.Size())
+			}
+			for mut := range orig_set.AllMutations() {
+				read_mut, ok := read_set.GetMutation(mut.Name)
+				if !ok {
+					t.Errorf("%s r%d: mutation %s is missing", namespace, revision, mut.Name)
+					continue
+				}
+				if mut.SqlHash() != read_mut.SqlHash() || mut.MetaHash() != read_mut.MetaHash() {
+					t.Errorf("%s r%d: mutation %s changed", namespace, revision, mut.Name)
+				}
+				if (mut.NewSql == nil) != (read_mut.NewSql == nil) || len(mut.NewSql) != len(read_mut.NewSql) {
+					t.Errorf("%s r%d: new_sql of %s changed", namespace, revision, mut.Name)
+				}
+			}
+		}
+	}
+}
@@ -4,23 +4,38 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
 	au "github.com/logrusorgru/aurora"
 	"github.com/samber/oops"
 )
 
 var _ Executor = &PgRunner{}
 
+// LogOutput is where the runners log. Commands that write their result to stdout should set it to stderr.
+var LogOutput io.Writer = os.Stdout
+
 type PgRunner struct {
 	uri     string
 	logger  *log.Logger
 	conn    *pgx.Conn
 	verbose bool
 	buf     bytes.Buffer
+
+	// namespaces whose advisory lock is held
+	locks []string
+
+	// restored after the mutations that have their own timeout
+	statement_timeout time.Duration
 }
 
 func (r *PgRunner) Logger() *log.Logger {
@@ -31,11 +46,11 @@ func (r *PgRunner) Close() error {
 	return r.conn.Close(context.Background())
 }
 
-func NewPgRunner(url string, verbose bool) (*PgRunner, error) {
+func NewPgRunner(ctx context.Context, url string, verbose bool) (*PgRunner, error) {
 
 	res := &PgRunner{uri: url, verbose: verbose}
 
-	res.logger = log.New(os.Stdout, "", log.Lshortfile|log.LstdFlags)
+	res.logger = log.New(LogOutput, "", log.Lshortfile|log.LstdFlags)
 	res.logger.SetPrefix(au.BrightGreen("pg ").String())
 
 	res.logger.Println("connecting to", url)
@@ -50,7 +65,13 @@ func NewPgRunner(url string, verbose bool) (*PgRunner, error) {
 		// res.logger.Printf("%+v\n", oo)
 	})
 
-	conn, err := pgx.ConnectConfig(context.Background(), config)
+	// a cancelled context cancels the running statement instead of closing the connection,
+	// so that the transaction can still be rolled back
+	config.BuildContextWatcherHandler = func(conn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: conn, DeadlineDelay: 5 * time.Second}
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +81,7 @@ func NewPgRunner(url string, verbose bool) (*PgRunner, error) {
 }
 
 func (r *PgRunner) ResumeLogging() {
-	r.logger.SetOutput(os.Stdout)
+	r.logger.SetOutput(LogOutput)
 	r.logger.SetPrefix("")
 }
 
@@ -104,79 +125,215 @@ func wrapPgError(err error, sql string) error {
 	return oo.Wrap(err)
 }
 
-func (r *PgRunner) Run(runnable *Runnable) error {
+func (r *PgRunner) Run(ctx context.Context, runnable *Runnable) error {
 	if runnable.Size() == 0 {
 		return nil
 	}
 	r.logger.Println(runnable.DisplayName())
+
+	// SET LOCAL has no effect outside of a transaction
+	if timeout := runnable.Mutation.Timeout; timeout != 0 && runnable.InTransaction() {
+		if err := r.setStatementTimeout(ctx, timeout); err != nil {
+			return err
+		}
+		defer r.setStatementTimeout(ctx, r.statement_timeout)
+	}
+
 	for i, stmt := range runnable.Statements() {
-		if err := r.exec(runnable.Mutation, stmt); err != nil {
-			oo := oops.With("statement index", i+1)
+		if err := r.exec(ctx, runnable.Mutation, stmt); err != nil {
+			oo := oops.With("runnable", runnable.DisplayName()).With("statement index", i+1)
+			if ctx.Err() != nil {
+				r.logger.Println(au.BrightRed("✗"), "interrupted while running", runnable.DisplayName())
+				return oo.Wrapf(ctx.Err(), "interrupted while running %s", runnable.DisplayName())
+			}
 			if oop2, ok := err.(*oops.OopsError); ok {
 				if _, ok := oop2.Context()["statement"]; !ok {
 					oo = oo.With("statement", au.BrightBlue(stmt).String())
 				}
 			}
+			var pgerr *pgconn.PgError
+			if errors.As(err, &pgerr) {
+				switch pgerr.Code {
+				case "57014":
+					oo = oo.Hint("the statement timed out, give the mutation a longer `timeout:` or use --statement-timeout")
+				case "55P03":
+					oo = oo.Hint("a lock could not be acquired in time, see --lock-timeout")
+				}
+			}
 			return oo.With("statement", stmt).Wrap(err)
 		}
 	}
 	return nil
 }
 
-func (r *PgRunner) ClearMutations(namespace string) error {
+// setStatementTimeout sets the statement_timeout of the current transaction, back to the server's default when 0.
+func (r *PgRunner) setStatementTimeout(ctx context.Context, timeout time.Duration) error {
+	if timeout == 0 {
+		return r.exec(ctx, nil, `SET LOCAL statement_timeout TO DEFAULT`)
+	}
+	return r.exec(ctx, nil, fmt.Sprintf(`SET LOCAL statement_timeout = %d`, timeout.Milliseconds()))
+}
+
+// SetTimeouts sets the statement_timeout and lock_timeout of the current transaction. Timeouts that are 0
+// are left to the server's configuration.
+func (r *PgRunner) SetTimeouts(ctx context.Context, statement_timeout time.Duration, lock_timeout time.Duration) error {
+	r.statement_timeout = statement_timeout
+	if statement_timeout != 0 {
+		if err := r.setStatementTimeout(ctx, statement_timeout); err != nil {
+			return err
+		}
+	}
+	if lock_timeout != 0 {
+		if err := r.exec(ctx, nil, fmt.Sprintf(`SET LOCAL lock_timeout = %d`, lock_timeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PgRunner) roleExists(ctx context.Context, role string) (bool, error) {
+	var exists bool
+	sql := `select exists (select 1 from pg_catalog.pg_roles where rolname = $1)`
+	if err := r.conn.QueryRow(ctx, sql, role).Scan(&exists); err != nil {
+		return false, wrapPgError(err, sql)
+	}
+	return exists, nil
+}
+
+// CreateMissingRoles creates the roles that do not exist yet. Roles are global to the cluster,
+// so they may already have been created by another database.
+func (r *PgRunner) CreateMissingRoles(ctx context.Context, roles []string) error {
+	for _, role := range roles {
+		exists, err := r.roleExists(ctx, role)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		r.logger.Println(au.BrightGreen("↑"), "role", au.BrightBlue(role))
+		if err := r.exec(ctx, nil, `CREATE ROLE `+pgx.Identifier{role}.Sanitize()); err != nil {
+			return oops.With("role", role).Wrap(err)
+		}
+	}
+	return nil
+}
+
+// DeclaredRoles returns the roles declared by the mutations recorded in the database, in any namespace.
+func (r *PgRunner) DeclaredRoles(ctx context.Context) ([]string, error) {
+	var roles []string
+	sql := `SELECT coalesce(array_agg(DISTINCT role ORDER BY role), '{}') FROM __dmut__.mutations, unnest(roles) role`
+	if err := r.conn.QueryRow(ctx, sql).Scan(&roles); err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	return roles, nil
+}
+
+// DropRoles drops the roles that no mutation recorded in the database declares anymore, in any namespace.
+// Roles are global to the cluster, so those that objects or privileges of other databases depend on are kept,
+// as are those that cannot be dropped. A transaction should be started before calling this function.
+func (r *PgRunner) DropRoles(ctx context.Context, roles []string) error {
+	sql := `SELECT
+		NOT EXISTS (SELECT 1 FROM __dmut__.mutations WHERE $1 = any(roles)),
+		NOT EXISTS (SELECT 1 FROM pg_catalog.pg_shdepend d
+			WHERE d.refclassid = 'pg_catalog.pg_authid'::regclass
+				AND d.refobjid = (SELECT oid FROM pg_catalog.pg_roles WHERE rolname = $1)
+				AND d.dbid <> (SELECT oid FROM pg_catalog.pg_database WHERE datname = current_database()))`
+	for _, role := range roles {
+		exists, err := r.roleExists(ctx, role)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		var undeclared, unused bool
+		if err := r.conn.QueryRow(ctx, sql, role).Scan(&undeclared, &unused); err != nil {
+			return wrapPgError(err, sql)
+		}
+		if !undeclared {
+			continue
+		}
+		if !unused {
+			r.logger.Println(au.BrightYellow("⚠"), "keeping role", au.BrightBlue(role), au.Faint("other databases depend on it"))
+			continue
+		}
+
+		// a role that still owns or is granted something is kept rather than failing the apply
+		if err := r.SavePoint(ctx, "drop_role"); err != nil {
+			return err
+		}
+		if err := r.exec(ctx, nil, `DROP ROLE `+pgx.Identifier{role}.Sanitize()); err != nil {
+			r.logger.Println(au.BrightYellow("⚠"), "keeping role", au.BrightBlue(role), au.Faint(err.Error()))
+			if err := r.RollbackToSavepoint(ctx, "drop_role"); err != nil {
+				return err
+			}
+		} else {
+			r.logger.Println(au.BrightRed("↓"), "role", au.BrightBlue(role))
+		}
+		if err := r.ReleaseSavepoint(ctx, "drop_role"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PgRunner) ClearMutations(ctx context.Context, namespace string) error {
 	sql := `DELETE FROM __dmut__.mutations WHERE namespace = $1`
-	err := r.exec(nil, sql, namespace)
+	err := r.exec(ctx, nil, sql, namespace)
 	return wrapPgError(err, sql)
 }
 
-func (r *PgRunner) Commit() error {
+func (r *PgRunner) Commit(ctx context.Context) error {
 	r.logger.Println(au.BrightGreen("💾"), "committing")
-	return r.exec(nil, `COMMIT`)
+	return r.exec(ctx, nil, `COMMIT`)
 }
 
-func (r *PgRunner) Begin() error {
+func (r *PgRunner) Begin(ctx context.Context) error {
 	// r.logger.Println(au.BrightGreen("💾"), "BEGIN")
-	return r.exec(nil, "BEGIN")
+	return r.exec(ctx, nil, "BEGIN")
 }
 
-func (r *PgRunner) Rollback() error {
+// Rollback is not cancelled with the context, as it is what has to run once the context was cancelled.
+func (r *PgRunner) Rollback(ctx context.Context) error {
 	r.logger.Println(au.BrightRed("↩"), "rolling back")
-	return r.exec(nil, "ROLLBACK")
+	return r.exec(context.WithoutCancel(ctx), nil, "ROLLBACK")
 }
 
-func (r *PgRunner) SavePoint(name string) error {
+func (r *PgRunner) SavePoint(ctx context.Context, name string) error {
 	if name == "" {
-		if err := r.exec(nil, "BEGIN"); err != nil {
+		if err := r.exec(ctx, nil, "BEGIN"); err != nil {
 			return wrapPgError(err, "BEGIN")
 		}
 		return nil
 	}
 	// r.logger.Println(au.BrightGreen("💾"), "saving point", name)
-	return r.exec(nil, `SAVEPOINT `+name)
+	return r.exec(ctx, nil, `SAVEPOINT `+name)
 }
 
-func (r *PgRunner) RollbackToSavepoint(name string) error {
+func (r *PgRunner) RollbackToSavepoint(ctx context.Context, name string) error {
 	var cmd = `ROLLBACK`
 	if name != "" {
 		cmd += ` TO SAVEPOINT ` + name
 	}
 	// r.logger.Println(au.BrightRed("💾"), "rolling back to point", name)
-	return r.exec(nil, cmd)
+	return r.exec(ctx, nil, cmd)
 }
 
-func (r *PgRunner) ReleaseSavepoint(name string) error {
-	return r.exec(nil, `RELEASE SAVEPOINT `+name)
+func (r *PgRunner) ReleaseSavepoint(ctx context.Context, name string) error {
+	return r.exec(ctx, nil, `RELEASE SAVEPOINT `+name)
 }
 
-func (r *PgRunner) Exec(sql string, args ...interface{}) error {
-	return r.exec(nil, sql, args...)
+func (r *PgRunner) Exec(ctx context.Context, sql string, args ...interface{}) error {
+	return r.exec(ctx, nil, sql, args...)
 }
 
-func (r *PgRunner) exec(mutation *Mutation, sql string, args ...interface{}) error {
+func (r *PgRunner) exec(ctx context.Context, mutation *Mutation, sql string, args ...interface{}) error {
 	if r.verbose {
 		r.logger.Println(au.BrightBlue(sql))
 	}
-	_, err := r.conn.Exec(context.Background(), sql, args...)
+	_, err := r.conn.Exec(ctx, sql, args...)
 	if err != nil {
 		err = wrapPgError(err, sql)
 		oo := oops.In("pg")
@@ -191,8 +348,35 @@ func (r *PgRunner) exec(mutation *Mutation, sql string, args ...interface{}) err
 	return err
 }
 
+// GetDBNamespaces lists the namespaces that have mutations saved in the database.
+func (r *PgRunner) GetDBNamespaces(ctx context.Context) ([]string, error) {
+	var exists bool
+	sql := `SELECT EXISTS (
+		SELECT 1
+		FROM pg_catalog.pg_namespace
+		WHERE nspname = '__dmut__'
+	)`
+	if err := r.conn.QueryRow(ctx, sql).Scan(&exists); err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	sql = `SELECT DISTINCT namespace FROM __dmut__.mutations ORDER BY namespace`
+	rows, err := r.conn.Query(ctx, sql)
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	namespaces, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	return namespaces, nil
+}
+
 // get the mutations already in the database
-func (r *PgRunner) GetDBMutationsFromDb(namespace string) (*MutationSet, error) {
+func (r *PgRunner) GetDBMutationsFromDb(ctx context.Context, namespace string) (*MutationSet, error) {
 	var (
 		db     = r.conn
 		exists bool
@@ -204,7 +388,7 @@ func (r *PgRunner) GetDBMutationsFromDb(namespace string) (*MutationSet, error)
 		FROM pg_catalog.pg_namespace
 		WHERE nspname = '__dmut__'
 	)`
-	if err := db.QueryRow(context.Background(), sql).Scan(&exists); err != nil {
+	if err := db.QueryRow(ctx, sql).Scan(&exists); err != nil {
 		return nil, wrapPgError(err, sql)
 	}
 	if !exists {
@@ -222,7 +406,7 @@ func (r *PgRunner) GetDBMutationsFromDb(namespace string) (*MutationSet, error)
 		) as data
 		from (select * from __dmut__.mutations WHERE namespace = $1) r
 	`
-	row := db.QueryRow(context.Background(), sql, namespace)
+	row := db.QueryRow(ctx, sql, namespace)
 
 	var json_text []byte
 	if err := row.Scan(&json_text); err != nil {
@@ -253,8 +437,8 @@ func (r *PgRunner) GetDBMutationsFromDb(namespace string) (*MutationSet, error)
 	return res, nil
 }
 
-func (r *PgRunner) SaveMutations(mutations *MutationSet) (err error) {
-	if err = r.ClearMutations(mutations.Namespace); err != nil {
+func (r *PgRunner) SaveMutations(ctx context.Context, mutations *MutationSet) (err error) {
+	if err = r.ClearMutations(ctx, mutations.Namespace); err != nil {
 		return err
 	}
 
@@ -262,6 +446,7 @@ func (r *PgRunner) SaveMutations(mutations *MutationSet) (err error) {
 	for m := range mutations.AllMutations() {
 
 		if m.ShouldBeSaved() {
+			m.storeHashes()
 			muts = append(muts, m)
 		}
 	}
@@ -271,6 +456,16 @@ func (r *PgRunner) SaveMutations(mutations *MutationSet) (err error) {
 		return err
 	}
 
+	// the variables are kept to know what the stored statements were rendered with
+	var vars = mutations.vars
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	var vars_json []byte
+	if vars_json, err = json.Marshal(vars); err != nil {
+		return oops.In("pg").With("namespace", mutations.Namespace).Wrapf(err, "error encoding variables")
+	}
+
 	sql := `
 		INSERT INTO __dmut__.mutations(
 			namespace,
@@ -282,7 +477,13 @@ func (r *PgRunner) SaveMutations(mutations *MutationSet) (err error) {
 			meta_needs,
 			meta,
 			sql,
-			new_sql
+			new_sql,
+			roles,
+			vars,
+			pending,
+			sql_hash,
+			meta_hash,
+			hash_version
 		)
 		SELECT
 			$2,
@@ -294,12 +495,39 @@ func (r *PgRunner) SaveMutations(mutations *MutationSet) (err error) {
 			coalesce(meta_needs, '{}'::text[]),
 			coalesce(meta, '{}'::__dmut__.mutation_statement[]),
 			coalesce(sql, '{}'::__dmut__.mutation_statement[]),
-			new_sql
+			new_sql,
+			coalesce(roles, '{}'::text[]),
+			$4::jsonb,
+			coalesce(pending, false),
+			sql_hash,
+			meta_hash,
+			hash_version
 		FROM json_populate_recordset(NULL::__dmut__.mutations, $1::json)
-		ON CONFLICT (namespace, name) DO UPDATE SET file = excluded.file, needs = excluded.needs, meta_needs = excluded.meta_needs, meta = excluded.meta, sql = excluded.sql, new_sql = excluded.new_sql`
+		ON CONFLICT (namespace, name) DO UPDATE SET file = excluded.file, needs = excluded.needs, meta_needs = excluded.meta_needs, meta = excluded.meta, sql = excluded.sql, new_sql = excluded.new_sql, roles = excluded.roles, vars = excluded.vars, pending = excluded.pending,
+			sql_hash = excluded.sql_hash, meta_hash = excluded.meta_hash, hash_version = excluded.hash_version`
 
-	if err := r.exec(nil, sql, muts_json, mutations.Namespace, mutations.Revision); err != nil {
+	if err := r.exec(ctx, nil, sql, muts_json, mutations.Namespace, mutations.Revision, vars_json); err != nil {
 		return wrapPgError(err, sql)
 	}
 	return nil
 }
+
+// MarkApplied records that the sql of a pending mutation ran.
+func (r *PgRunner) MarkApplied(ctx context.Context, mutation *Mutation) error {
+	sql := `UPDATE __dmut__.mutations SET pending = false WHERE namespace = $1 AND name = $2`
+	if err := r.exec(ctx, nil, sql, mutation.Namespace, mutation.Name); err != nil {
+		return err
+	}
+	mutation.Pending = false
+	return nil
+}
+
+// IsMissingObject tells if an error is postgres complaining that the object a statement works on does not exist.
+func IsMissingObject(err error) bool {
+	var pgerr *pgconn.PgError
+	if errors.As(err, &pgerr) {
+		// undefined_object, undefined_table, undefined_function, undefined_column, invalid_schema_name
+		return slices.Contains([]string{"42704", "42P01", "42883", "42703", "3F000"}, pgerr.Code)
+	}
+	return false
+}
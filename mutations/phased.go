@@ -0,0 +1,283 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
+// A phased apply commits every step of the plan in its own transaction, instead of running everything in a single one,
+// and records the steps that were done in __dmut__.progress. The mutations themselves are only recorded once all the
+// steps are done, so that an interrupted apply computes the same plan again and skips what was already done.
+
+const rolesStep = "roles"
+
+// Key names the runnable among the steps of a phased apply.
+func (r *Runnable) Key() string {
+	var dir = "up"
+	if r.Direction.Down {
+		dir = "down"
+	}
+	var kind = "sql"
+	if r.Direction.Meta {
+		kind = "meta"
+	}
+	return fmt.Sprintf("%s %s %s", kind, dir, r.Mutation.Name)
+}
+
+// phasedStep is either a runnable, or the creation of the missing roles when runnable is nil.
+type phasedStep struct {
+	key      string
+	runnable *Runnable
+}
+
+// steps lists what runs in the transaction, in the order Run would run it.
+func (p *MutationPlan) steps() []phasedStep {
+	var res []phasedStep
+	var add = func(rm *RunnableMap) {
+		for _, runnable := range rm.Values() {
+			if !runnable.IsEmpty() {
+				res = append(res, phasedStep{key: runnable.Key(), runnable: runnable})
+			}
+		}
+	}
+	add(p.MetaDown)
+	if len(p.NewRoles) > 0 {
+		res = append(res, phasedStep{key: rolesStep})
+	}
+	add(p.SqlDown)
+	add(p.SqlUp)
+	add(p.MetaUp)
+	return res
+}
+
+func stepKeys(steps []phasedStep) []string {
+	var res []string
+	for _, step := range steps {
+		res = append(res, step.key)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// inTransaction runs fn in its own transaction, with the timeouts set.
+func inTransaction(ctx context.Context, runner Executor, options *MutationRunnerOptions, fn func() error) error {
+	if err := runner.Begin(ctx); err != nil {
+		return err
+	}
+	if err := runner.SetTimeouts(ctx, options.StatementTimeout, options.LockTimeout); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if err2 := runner.Rollback(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
+		return err
+	}
+	return runner.Commit(ctx)
+}
+
+// runAllPhased is RunAllMutations when Phased is set.
+func runAllPhased(ctx context.Context, runner Executor, namespaces *MutationNamespace, options *MutationRunnerOptions, opts ...*MutationRunnerOptions) error {
+	if !options.Commit {
+		return oops.In("phased").Errorf("a phased apply commits as it goes and cannot be a dry run")
+	}
+	if options.Override {
+		return oops.In("phased").Errorf("a phased apply runs the plan one step at a time and cannot override the recorded mutations")
+	}
+
+	// the session level locks are held from one transaction to the next
+	defer func() {
+		if err := runner.Unlock(ctx); err != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err)
+		}
+	}()
+
+	// the locks are taken outside of the transactions, whose statement_timeout would cancel the wait
+	if err := runner.Lock(ctx, DMUT_NAMESPACE, options.LockTimeout); err != nil {
+		return err
+	}
+
+	var declared_roles []string
+	if err := inTransaction(ctx, runner, options, func() error {
+		if err := Bootstrap(ctx, runner, opts...); err != nil {
+			return err
+		}
+		var err error
+		declared_roles, err = runner.DeclaredRoles(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var deferred []*Runnable
+	for _, namespace := range namespaces.Namespaces() {
+		if err := runner.Lock(ctx, namespace, options.LockTimeout); err != nil {
+			return err
+		}
+
+		db_mutations, err := runner.GetDBMutationsFromDb(ctx, namespace)
+		if err != nil {
+			return err
+		}
+
+		revisions, ok := namespaces.Map.Get(namespace)
+		if !ok {
+			return oops.In("mutations").With("namespace", namespace).Errorf("no revision sequence found")
+		}
+
+		var to_apply = revisions.RevisionsToApply(db_mutations.Revision, options.All)
+		if options.Resume {
+			// an apply interrupted in a later revision resumes there, the revisions before it were all saved
+			progress, err := runner.GetProgress(ctx, namespace)
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				to_apply = slices.DeleteFunc(to_apply, func(set *MutationSet) bool { return set.Revision < progress.Revision })
+			}
+		}
+
+		var namespace_deferred []*Runnable
+		for _, revision := range to_apply {
+			if namespace_deferred, err = runPhased(ctx, runner, revision, options); err != nil {
+				return err
+			}
+		}
+		deferred = append(deferred, namespace_deferred...)
+	}
+
+	// once all the namespaces were saved, the roles none of them declares anymore can go
+	if err := inTransaction(ctx, runner, options, func() error {
+		return runner.DropRoles(ctx, declared_roles)
+	}); err != nil {
+		return err
+	}
+
+	runner.Logger().Println(au.BrightGreen("🎉"), "no errors")
+	return RunDeferred(ctx, runner, deferred)
+}
+
+// runPhased applies a revision of a namespace one step at a time, resuming an interrupted apply if Resume is set.
+func runPhased(ctx context.Context, runner Executor, local *MutationSet, options *MutationRunnerOptions) ([]*Runnable, error) {
+	var (
+		plan     *MutationPlan
+		steps    []phasedStep
+		progress *Progress
+	)
+
+	// the plan is computed and the progress checked in a first transaction, as pending mutations may be downed
+	if err := inTransaction(ctx, runner, options, func() error {
+		selected, ok, err := selectMutations(ctx, runner, local, options)
+		if err != nil || !ok {
+			return err
+		}
+		local = selected
+
+		distant, err := getDistant(ctx, runner, local)
+		if err != nil {
+			return err
+		}
+		if distant, err = resumePending(ctx, runner, distant); err != nil {
+			return err
+		}
+
+		plan = NewMutationPlan(local, distant)
+		steps = plan.steps()
+		if options.OnPlan != nil {
+			options.OnPlan(plan)
+		}
+		if options.GuardSqlDown {
+			if err := checkSqlDowns(runner, local, plan, options.AllowSqlDown); err != nil {
+				return err
+			}
+		}
+
+		if progress, err = runner.GetProgress(ctx, local.Namespace); err != nil {
+			return err
+		}
+		if progress == nil {
+			progress = &Progress{Namespace: local.Namespace, Revision: local.Revision, Steps: stepKeys(steps), Done: []string{}}
+			if err := runner.SaveProgress(ctx, progress); err != nil {
+				return err
+			}
+			// the steps are added to the history as they are committed, by this apply and the ones that resume it
+			if !plan.HasChanges() {
+				return nil
+			}
+			return runner.SaveHistory(ctx, NewHistory(local.Namespace, distant.Revision, local.Revision))
+		}
+
+		oo := oops.In("phased").With("namespace", local.Namespace).With("started", progress.Started).With("done", len(progress.Done)).With("steps", len(progress.Steps))
+		if !options.Resume {
+			return oo.Hint("use --resume to continue it").Errorf("a phased apply of namespace %s was interrupted", local.Namespace)
+		}
+		if progress.Revision != local.Revision || !slices.Equal(progress.Steps, stepKeys(steps)) {
+			return oo.With("revision", progress.Revision).Errorf("the mutations of namespace %s changed since the interrupted apply", local.Namespace)
+		}
+		runner.Logger().Println(au.BrightYellow("↻"), "resuming namespace", au.BrightMagenta(local.Namespace).String(), "after", len(progress.Done), "of", len(progress.Steps), "steps")
+		return nil
+	}); err != nil || plan == nil {
+		return nil, err
+	}
+
+	for _, step := range steps {
+		if slices.Contains(progress.Done, step.key) {
+			continue
+		}
+		if err := inTransaction(ctx, runner, options, func() error {
+			var history = &History{}
+			if step.runnable == nil {
+				if err := runner.CreateMissingRoles(ctx, plan.Roles); err != nil {
+					return err
+				}
+			} else if err := (&historyRecorder{Executor: runner, history: history}).Run(ctx, step.runnable); err != nil {
+				return err
+			}
+			if err := runner.AppendHistory(ctx, local.Namespace, history.Runs...); err != nil {
+				return err
+			}
+			progress.Done = append(progress.Done, step.key)
+			return runner.SaveProgress(ctx, progress)
+		}); err != nil {
+			// the step was rolled back, along with its progress
+			return nil, oops.With("done", len(progress.Done)).With("steps", len(steps)).Hint("use --resume to continue").Wrap(err)
+		}
+	}
+
+	// the mutations are recorded and tested in a last transaction, which also forgets the progress
+	var deferred = plan.Deferred.Values()
+	if err := inTransaction(ctx, runner, options, func() error {
+		for _, runnable := range deferred {
+			runnable.Mutation.Pending = true
+		}
+		if err := runner.SaveMutations(ctx, local); err != nil {
+			return err
+		}
+		testable, err := local.filter(func(mut *Mutation) bool { return !mut.NoTransaction })
+		if err != nil {
+			return err
+		}
+		if plan.HasChanges() {
+			runner.Logger().Println(au.BrightGreen("🧪"), "performing tests")
+			if err := TestMutationSet(ctx, runner, testable); err != nil {
+				return err
+			}
+		}
+		if testable.HasOverrides {
+			runner.Logger().Println(au.BrightGreen("🧪"), "performing tests with new_*")
+			if err := TestMutationSet(ctx, runner, testable.AsNewMutationSet()); err != nil {
+				return err
+			}
+		}
+		return runner.ClearProgress(ctx, local.Namespace)
+	}); err != nil {
+		return nil, err
+	}
+	runner.Logger().Println(au.BrightGreen("✓"), "tests passed")
+
+	return deferred, nil
+}
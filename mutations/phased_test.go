@@ -0,0 +1,205 @@
+package mutations
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memoryRunner keeps the recorded mutations and the progress in memory, and only commits them with the transaction.
+type memoryRunner struct {
+	logger   *log.Logger
+	testing  bool
+	tested   int
+	fail_on  string
+	ran      map[string]int
+	state    memoryState
+	rollback *memoryState
+}
+
+type memoryState struct {
+	sets     map[string]*MutationSet
+	progress map[string]Progress
+}
+
+func newMemoryRunner() *memoryRunner {
+	return &memoryRunner{
+		logger: log.New(io.Discard, "", 0),
+		ran:    map[string]int{},
+		state:  memoryState{sets: map[string]*MutationSet{}, progress: map[string]Progress{}},
+	}
+}
+
+func (st memoryState) copy() memoryState {
+	res := memoryState{sets: map[string]*MutationSet{}, progress: map[string]Progress{}}
+	for k, v := range st.sets {
+		res.sets[k] = v
+	}
+	for k, v := range st.progress {
+		res.progress[k] = v
+	}
+	return res
+}
+
+func (r *memoryRunner) Logger() *log.Logger     { return r.logger }
+func (r *memoryRunner) ResumeLogging()          { r.testing = false }
+func (r *memoryRunner) SetTesting()             { r.testing, r.tested = true, r.tested+1 }
+func (r *memoryRunner) GetStringOutput() string { return "" }
+func (r *memoryRunner) Close() error            { return nil }
+
+func (r *memoryRunner) Exec(ctx context.Context, sql string, args ...interface{}) error { return nil }
+
+func (r *memoryRunner) Begin(ctx context.Context) error {
+	var st = r.state.copy()
+	r.rollback = &st
+	return nil
+}
+
+func (r *memoryRunner) Rollback(ctx context.Context) error {
+	r.state, r.rollback = *r.rollback, nil
+	return nil
+}
+
+func (r *memoryRunner) Commit(ctx context.Context) error {
+	r.rollback = nil
+	return nil
+}
+
+func (r *memoryRunner) SavePoint(ctx context.Context, name string) error           { return nil }
+func (r *memoryRunner) RollbackToSavepoint(ctx context.Context, name string) error { return nil }
+func (r *memoryRunner) ReleaseSavepoint(ctx context.Context, name string) error    { return nil }
+func (r *memoryRunner) SetTimeouts(ctx context.Context, statement_timeout time.Duration, lock_timeout time.Duration) error {
+	return nil
+}
+
+func (r *memoryRunner) Lock(ctx context.Context, namespace string, timeout time.Duration) error {
+	return nil
+}
+func (r *memoryRunner) Unlock(ctx context.Context) error { return nil }
+
+func (r *memoryRunner) GetDBMutationsFromDb(ctx context.Context, namespace string) (*MutationSet, error) {
+	set, ok := r.state.sets[namespace]
+	if !ok {
+		return NewMutationSet(namespace, 0, ""), nil
+	}
+	return set.filter(func(mut *Mutation) bool { return true })
+}
+
+func (r *memoryRunner) GetDBNamespaces(ctx context.Context) ([]string, error) {
+	return slices.Sorted(maps.Keys(r.state.sets)), nil
+}
+
+func (r *memoryRunner) CreateMissingRoles(ctx context.Context, roles []string) error { return nil }
+func (r *memoryRunner) DeclaredRoles(ctx context.Context) ([]string, error)          { return nil, nil }
+func (r *memoryRunner) DropRoles(ctx context.Context, roles []string) error          { return nil }
+
+func (r *memoryRunner) ClearMutations(ctx context.Context, namespace string) error {
+	delete(r.state.sets, namespace)
+	return nil
+}
+
+func (r *memoryRunner) SaveMutations(ctx context.Context, set *MutationSet) (err error) {
+	r.state.sets[set.Namespace], err = set.filter(func(mut *Mutation) bool { return true })
+	return err
+}
+
+func (r *memoryRunner) MarkApplied(ctx context.Context, mutation *Mutation) error { return nil }
+
+func (r *memoryRunner) GetProgress(ctx context.Context, namespace string) (*Progress, error) {
+	if progress, ok := r.state.progress[namespace]; ok {
+		return &progress, nil
+	}
+	return nil, nil
+}
+
+func (r *memoryRunner) SaveProgress(ctx context.Context, progress *Progress) error {
+	var saved = *progress
+	saved.Done = append([]string{}, progress.Done...)
+	r.state.progress[progress.Namespace] = saved
+	return nil
+}
+
+func (r *memoryRunner) ClearProgress(ctx context.Context, namespace string) error {
+	delete(r.state.progress, namespace)
+	return nil
+}
+
+func (r *memoryRunner) SaveHistory(ctx context.Context, history *History) error { return nil }
+func (r *memoryRunner) AppendHistory(ctx context.Context, namespace string, runs ...*HistoryRun) error {
+	return nil
+}
+
+func (r *memoryRunner) Run(ctx context.Context, runnable *Runnable) error {
+	if r.testing || runnable.IsEmpty() {
+		return nil
+	}
+	if runnable.Key() == r.fail_on {
+		r.fail_on = ""
+		return errors.New("interrupted")
+	}
+	r.ran[runnable.Key()]++
+	return nil
+}
+
+// An apply interrupted in a later revision than the database's is resumed in that revision, and the revisions
+// without changes are not tested.
+func TestPhasedResumeRevisions(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"r1.yml": "__namespace: shop\n__revision: 1\nbase:\n  sql:\n    - create schema base;\n",
+		"r2.yml": "__namespace: shop\n__revision: 2\nbase:\n  sql:\n    - create schema base;\na:\n  sql:\n    - create schema a;\nb:\n  needs: [a]\n  sql:\n    - create schema b;\n",
+		"r3.yml": "__namespace: shop\n__revision: 3\nbase:\n  sql:\n    - create schema base;\na:\n  sql:\n    - create schema a;\nb:\n  needs: [a]\n  sql:\n    - create schema b;\nc:\n  sql:\n    - create schema c;\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	namespaces, err := LoadYamlMutations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := namespaces.KeepNamespaces([]string{"shop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runner := newMemoryRunner()
+	revision, _ := namespaces.Map.Get("shop")
+	if err := runner.SaveMutations(ctx, revision.Revisions[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	runner.fail_on = "sql up b"
+	var options = &MutationRunnerOptions{Commit: true, Phased: true}
+	if err := RunAllMutations(ctx, runner, namespaces, options); err == nil {
+		t.Fatalf("expected the apply to be interrupted")
+	}
+	if progress, _ := runner.GetProgress(ctx, "shop"); progress == nil || progress.Revision != 2 {
+		t.Fatalf("expected an interrupted apply of revision 2, got %+v", progress)
+	}
+	if runner.tested != 0 {
+		t.Errorf("revision 1 had no changes and should not have been tested")
+	}
+
+	if err := RunAllMutations(ctx, runner, namespaces, &MutationRunnerOptions{Commit: true, Resume: true}); err != nil {
+		t.Fatalf("expected the apply to resume, got %v", err)
+	}
+	if set, _ := runner.GetDBMutationsFromDb(ctx, "shop"); set.Revision != 3 {
+		t.Errorf("expected the database at revision 3, got %d", set.Revision)
+	}
+	if progress, _ := runner.GetProgress(ctx, "shop"); progress != nil {
+		t.Errorf("expected the progress to be cleared, got %+v", progress)
+	}
+	for key, count := range map[string]int{"sql up a": 1, "sql up b": 1, "sql up c": 1} {
+		if runner.ran[key] != count {
+			t.Errorf("expected %s to run %d times, got %d", key, count, runner.ran[key])
+		}
+	}
+}
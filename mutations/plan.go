@@ -0,0 +1,305 @@
+package mutations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+// MutationPlan holds the runnables that bring a database from the distant set to the local one,
+// in the order in which they have to run.
+type MutationPlan struct {
+	Namespace string
+	Revision  int
+
+	MetaDown *RunnableMap
+	SqlDown  *RunnableMap
+	SqlUp    *RunnableMap
+	MetaUp   *RunnableMap
+
+	// The sql up of the mutations with transaction: false, run once the transaction was committed.
+	Deferred *RunnableMap
+
+	// Roles declared by the local set, created before any sql if they are missing.
+	Roles []string
+	// Roles that were not declared before, and roles that the namespace does not declare anymore. Roles are shared
+	// by all the namespaces, the obsolete ones are only dropped at the end of the apply if nothing declares them.
+	NewRoles      []string
+	ObsoleteRoles []string
+}
+
+// NewMutationPlan computes the plan for a distant set whose new_* overrides were already applied if need be.
+func NewMutationPlan(local *MutationSet, distant *MutationSet) *MutationPlan {
+	plan := &MutationPlan{
+		Namespace: local.Namespace,
+		Revision:  local.Revision,
+	}
+
+	plan.SqlDown, plan.SqlUp = local.GetMutationsDelta(distant, ITER_SQL)
+	plan.MetaDown, plan.MetaUp = local.GetMutationsDelta(distant, ITER_META)
+
+	plan.Deferred = NewRunnableMap()
+	for _, key := range plan.SqlUp.Keys() {
+		if runnable, _ := plan.SqlUp.Get(key); runnable.Mutation.NoTransaction {
+			plan.Deferred.Put(key, runnable)
+			plan.SqlUp.Remove(key)
+		}
+	}
+
+	plan.Roles = local.Roles()
+	var distant_roles = distant.Roles()
+	for _, role := range plan.Roles {
+		if !slices.Contains(distant_roles, role) {
+			plan.NewRoles = append(plan.NewRoles, role)
+		}
+	}
+	for _, role := range distant_roles {
+		if !slices.Contains(plan.Roles, role) {
+			plan.ObsoleteRoles = append(plan.ObsoleteRoles, role)
+		}
+	}
+
+	if plan.SqlDown.Size() > 0 || len(plan.ObsoleteRoles) > 0 {
+		// all the meta is downed and re-applied when sql has to be downed or roles removed, as it may reference them
+		var fake_empty_local_set *MutationSet = nil
+		_, plan.MetaUp = local.GetMutationsDelta(nil, ITER_META)
+		plan.MetaDown, _ = fake_empty_local_set.GetMutationsDelta(distant, ITER_META)
+	}
+
+	return plan
+}
+
+func (p *MutationPlan) HasChanges() bool {
+	return p.SqlUp.Size() != 0 || p.MetaUp.Size() != 0 || p.SqlDown.Size() != 0 || p.MetaDown.Size() != 0 ||
+		p.Deferred.Size() != 0 || len(p.NewRoles) != 0 || len(p.ObsoleteRoles) != 0
+}
+
+// Runnables iterates on all the non-empty runnables in execution order.
+func (p *MutationPlan) Runnables() iter.Seq[*Runnable] {
+	return func(yield func(*Runnable) bool) {
+		for _, rm := range []*RunnableMap{p.MetaDown, p.SqlDown, p.SqlUp, p.MetaUp, p.Deferred} {
+			for _, runnable := range rm.Values() {
+				if runnable.IsEmpty() {
+					continue
+				}
+				if !yield(runnable) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Run downs the meta, then creates the missing roles before running the sql and the meta up. Deferred is left to
+// RunDeferred, and the obsolete roles to DropRoles once all the namespaces were saved.
+func (p *MutationPlan) Run(ctx context.Context, runner Executor) error {
+	if err := p.MetaDown.Run(ctx, runner); err != nil {
+		return err
+	}
+
+	if err := runner.CreateMissingRoles(ctx, p.Roles); err != nil {
+		return err
+	}
+
+	for _, rm := range []*RunnableMap{p.SqlDown, p.SqlUp, p.MetaUp} {
+		if err := rm.Run(ctx, runner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsDataDestroying tells if a statement loses data when run, such as DROP TABLE or ALTER TABLE ... DROP COLUMN
+func IsDataDestroying(stmt string) bool {
+	tokens, err := split(stmt)
+	if err != nil || len(tokens) < 2 {
+		return false
+	}
+	var is = func(i int, value string) bool {
+		return i < len(tokens) && strings.EqualFold(tokens[i].Value, value)
+	}
+
+	if is(0, "drop") && is(1, "table") {
+		return true
+	}
+
+	if is(0, "alter") && is(1, "table") {
+		for i := 3; i < len(tokens); i++ {
+			if is(i, "drop") && is(i+1, "column") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// DestroysData tells if any of the statements of a sql down loses data.
+func (r *Runnable) DestroysData() bool {
+	if !r.Direction.Down || r.Direction.Meta {
+		return false
+	}
+	for _, stmt := range r.Statements() {
+		if IsDataDestroying(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the runnables of the plan with their statements, flagging the ones that lose data.
+func (p *MutationPlan) Print(w io.Writer) {
+	if !p.HasChanges() {
+		fmt.Fprintln(w, au.BrightGreen("≡"), "no changes for namespace", au.BrightMagenta(p.Namespace), "revision", au.BrightGreen(p.Revision))
+		return
+	}
+
+	fmt.Fprintln(w, au.BrightGreen("→"), "plan for namespace", au.BrightMagenta(p.Namespace), "revision", au.BrightGreen(p.Revision))
+	var roles_printed = false
+	var print_roles = func() {
+		for _, role := range p.ObsoleteRoles {
+			fmt.Fprintln(w, ITER_SQL_DOWN.UpOrDown(), "role", au.BrightBlue(role), au.Faint("if no other namespace declares it"))
+		}
+		for _, role := range p.NewRoles {
+			fmt.Fprintln(w, ITER_SQL_UP.UpOrDown(), "role", au.BrightBlue(role))
+		}
+		roles_printed = true
+	}
+
+	for runnable := range p.Runnables() {
+		if !roles_printed && runnable.Direction != ITER_META_DOWN {
+			print_roles()
+		}
+		if !runnable.InTransaction() {
+			fmt.Fprintln(w, runnable.DisplayName(), au.BrightYellow("after commit"))
+		} else {
+			fmt.Fprintln(w, runnable.DisplayName())
+		}
+		destroys_data := runnable.DestroysData()
+		for _, stmt := range runnable.Statements() {
+			if stmt == "" {
+				continue
+			}
+			if destroys_data && IsDataDestroying(stmt) {
+				fmt.Fprintln(w, au.BrightRed(indent(stmt, "    ")), au.BrightRed("⚠ destroys data").Bold())
+			} else {
+				fmt.Fprintln(w, au.Faint(indent(stmt, "    ")))
+			}
+		}
+	}
+	if !roles_printed {
+		print_roles()
+	}
+}
+
+type jsonRunnable struct {
+	Mutation     string             `json:"mutation"`
+	File         string             `json:"file"`
+	Direction    IterationDirection `json:"direction"`
+	DestroysData bool               `json:"destroys_data"`
+	Transaction  bool               `json:"transaction"`
+	Statements   []string           `json:"statements"`
+}
+
+type jsonPlan struct {
+	Namespace     string          `json:"namespace"`
+	Revision      int             `json:"revision"`
+	HasChanges    bool            `json:"has_changes"`
+	NewRoles      []string        `json:"new_roles"`
+	ObsoleteRoles []string        `json:"obsolete_roles"`
+	Runnables     []*jsonRunnable `json:"runnables"`
+}
+
+func (p *MutationPlan) MarshalJSON() ([]byte, error) {
+	res := jsonPlan{
+		Namespace:     p.Namespace,
+		Revision:      p.Revision,
+		HasChanges:    p.HasChanges(),
+		NewRoles:      slices.Concat([]string{}, p.NewRoles),
+		ObsoleteRoles: slices.Concat([]string{}, p.ObsoleteRoles),
+		Runnables:     []*jsonRunnable{},
+	}
+	for runnable := range p.Runnables() {
+		jr := &jsonRunnable{
+			Mutation:     runnable.Mutation.Name,
+			File:         runnable.Mutation.File,
+			Direction:    runnable.Direction,
+			DestroysData: runnable.DestroysData(),
+			Transaction:  runnable.InTransaction(),
+			Statements:   []string{},
+		}
+		for _, stmt := range runnable.Statements() {
+			if stmt != "" {
+				jr.Statements = append(jr.Statements, stmt)
+			}
+		}
+		res.Runnables = append(res.Runnables, jr)
+	}
+	return json.Marshal(res)
+}
+
+// WritePlansJson writes the plans as a single json document meant to be read by other tools.
+func WritePlansJson(w io.Writer, plans []*MutationPlan) error {
+	if plans == nil {
+		plans = []*MutationPlan{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{"plans": plans})
+}
+
+// PlanAllMutations computes, without running anything, the plans that RunAllMutations would follow.
+func PlanAllMutations(ctx context.Context, runner Executor, namespaces *MutationNamespace, opts ...*MutationRunnerOptions) ([]*MutationPlan, error) {
+	var options = MutationRunnerOptions{}
+	options.Merge(opts...)
+
+	var selection = NewSelection(options.Only, options.Exclude)
+
+	var plans []*MutationPlan
+
+	bootstrap, err := PlanBootstrap(ctx, runner)
+	if err != nil {
+		return nil, err
+	}
+	if bootstrap.HasChanges() {
+		plans = append(plans, bootstrap)
+	}
+
+	for _, namespace := range namespaces.Namespaces() {
+		distant, err := runner.GetDBMutationsFromDb(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if err := distant.CheckHashes(); err != nil {
+			return nil, err
+		}
+		// pending mutations are run again by apply
+		if distant, err = distant.withoutPending(); err != nil {
+			return nil, err
+		}
+
+		revisions, _ := namespaces.Map.Get(namespace)
+		for _, local := range revisions.RevisionsToApply(distant.Revision, options.All) {
+			if !selection.IsEmpty() {
+				selected, ok, err := selection.Select(local, distant.ForRevision(local.Revision))
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				local = selected
+			}
+			plans = append(plans, NewMutationPlan(local, distant.ForRevision(local.Revision)))
+			// once applied, the local set is what the database holds
+			distant = local
+		}
+	}
+	return plans, nil
+}
@@ -0,0 +1,165 @@
+package mutations
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func loadYamlString(t *testing.T, contents string) *MutationSet {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	muts, err := LoadYamlMutations(file)
+	if err != nil {
+		t.Fatalf("error loading mutations: %v", err)
+	}
+	return muts.Latest("")
+}
+
+// Removing a role downs all the meta, since it may be referenced by grants or policies.
+func TestPlanRoles(t *testing.T) {
+	distant := loadYamlString(t, `
+auth:
+  roles: [admin, guest]
+  sql:
+    - create schema auth;
+  meta:
+    - grant usage on schema auth to guest;
+api:
+  sql:
+    - create schema api;
+  meta:
+    - grant usage on schema api to admin;
+`)
+	local := loadYamlString(t, `
+auth:
+  roles: [admin, user]
+  sql:
+    - create schema auth;
+  meta:
+    - grant usage on schema auth to guest;
+api:
+  sql:
+    - create schema api;
+  meta:
+    - grant usage on schema api to admin;
+`)
+
+	plan := NewMutationPlan(local, distant)
+	if !slices.Equal(plan.Roles, []string{"admin", "user"}) {
+		t.Errorf("expected roles admin, user, got %v", plan.Roles)
+	}
+	if !slices.Equal(plan.NewRoles, []string{"user"}) {
+		t.Errorf("expected new role user, got %v", plan.NewRoles)
+	}
+	if !slices.Equal(plan.ObsoleteRoles, []string{"guest"}) {
+		t.Errorf("expected obsolete role guest, got %v", plan.ObsoleteRoles)
+	}
+	if plan.SqlDown.Size() != 0 {
+		t.Errorf("roles should not down any sql, got %v", plan.SqlDown.Keys())
+	}
+	if plan.MetaDown.Size() != 2 || plan.MetaUp.Size() != 2 {
+		t.Errorf("all the meta should be downed and upped, got %v and %v", plan.MetaDown.Keys(), plan.MetaUp.Keys())
+	}
+
+	if plan := NewMutationPlan(local, local); plan.HasChanges() {
+		t.Errorf("the same set should not have changes")
+	}
+}
+
+// A timeout is not part of the sql, changing it does not down anything.
+func TestPlanTimeout(t *testing.T) {
+	distant := loadYamlString(t, `
+big:
+  sql:
+    - create index big_idx on big (id);
+`)
+	local := loadYamlString(t, `
+big:
+  timeout: 10m
+  sql:
+    - create index big_idx on big (id);
+`)
+
+	mut, _ := local.GetMutation("big")
+	if mut.Timeout != 10*time.Minute {
+		t.Errorf("expected a timeout of 10m, got %s", mut.Timeout)
+	}
+	if plan := NewMutationPlan(local, distant); plan.HasChanges() {
+		t.Errorf("changing the timeout should not have changes")
+	}
+}
+
+// The sql of mutations with transaction: false is deferred, and nothing may need it.
+func TestPlanOutsideTransaction(t *testing.T) {
+	distant := loadYamlString(t, `
+big:
+  sql:
+    - create table big (id int);
+`)
+	local := loadYamlString(t, `
+big:
+  sql:
+    - create table big (id int);
+  children:
+    idx:
+      transaction: false
+      sql:
+        - up: create index concurrently big_idx on big (id);
+          down: drop index big_idx;
+`)
+
+	plan := NewMutationPlan(local, distant)
+	if keys := plan.Deferred.Keys(); len(keys) != 1 || plan.SqlUp.Size() != 0 {
+		t.Errorf("expected big.idx to be deferred, got %v and %v", keys, plan.SqlUp.Keys())
+	}
+	for runnable := range plan.Runnables() {
+		if runnable.InTransaction() {
+			t.Errorf("%s should run outside of the transaction", runnable.DisplayName())
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte(`
+big:
+  transaction: false
+  sql:
+    - create table big (id int);
+big.id:
+  sql:
+    - alter table big add column other int;
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadYamlMutations(file); err == nil {
+		t.Errorf("expected an error, big.id needs a mutation outside of the transaction")
+	}
+}
+
+// A phased apply records its steps by key, the roles sync being a step of its own.
+func TestPlanSteps(t *testing.T) {
+	distant := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+`)
+	local := loadYamlString(t, `
+auth:
+  roles: [admin]
+  sql:
+    - create schema auth;
+  meta:
+    - grant usage on schema auth to admin;
+`)
+
+	plan := NewMutationPlan(local, distant)
+	keys := stepKeys(plan.steps())
+	if !slices.Equal(keys, []string{"meta up auth", rolesStep}) {
+		t.Errorf("expected the meta up and the roles, got %v", keys)
+	}
+}
@@ -0,0 +1,41 @@
+package mutations
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Progress is what a phased apply of a namespace recorded of its steps, so that it can be resumed.
+type Progress struct {
+	Namespace string
+	Revision  int
+	Steps     []string
+	Done      []string
+	Started   time.Time
+}
+
+// GetProgress returns the progress of an interrupted phased apply of the namespace, or nil if there is none.
+func (r *PgRunner) GetProgress(ctx context.Context, namespace string) (*Progress, error) {
+	var res = Progress{Namespace: namespace}
+	sql := `SELECT revision, steps, done, started FROM __dmut__.progress WHERE namespace = $1`
+	err := r.conn.QueryRow(ctx, sql, namespace).Scan(&res.Revision, &res.Steps, &res.Done, &res.Started)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapPgError(err, sql)
+	}
+	return &res, nil
+}
+
+func (r *PgRunner) SaveProgress(ctx context.Context, progress *Progress) error {
+	sql := `INSERT INTO __dmut__.progress(namespace, revision, steps, done) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (namespace) DO UPDATE SET revision = excluded.revision, steps = excluded.steps, done = excluded.done, updated = now()`
+	return r.exec(ctx, nil, sql, progress.Namespace, progress.Revision, progress.Steps, progress.Done)
+}
+
+func (r *PgRunner) ClearProgress(ctx context.Context, namespace string) error {
+	return r.exec(ctx, nil, `DELETE FROM __dmut__.progress WHERE namespace = $1`, namespace)
+}
@@ -0,0 +1,94 @@
+package mutations
+
+import (
+	"slices"
+)
+
+// ForRevision returns the set as it should be compared to a set of the given revision. When the revision
+// is higher, the new_* overrides replace the original sql and needs.
+func (ms *MutationSet) ForRevision(revision int) *MutationSet {
+	if ms != nil && ms.HasOverrides && ms.Revision < revision {
+		return ms.AsNewMutationSet()
+	}
+	return ms
+}
+
+func sameNeeds(name string, a []string, b []string) bool {
+	a = ExplicitNeeds(name, a)
+	b = ExplicitNeeds(name, b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// CreateRevision computes the revision that follows previous and brings it to local without downing anything.
+// Mutations whose sql or needs changed keep their previous definition, and get the local one in new_sql and new_needs.
+// Mutations that are not in local anymore are retired with empty new_sql and new_needs.
+func CreateRevision(previous *MutationSet, local *MutationSet) (*MutationSet, error) {
+	var revision = 1
+	if previous != nil {
+		revision = previous.Revision + 1
+		previous = previous.ForRevision(revision)
+	}
+	if local.HasOverrides {
+		local = local.AsNewMutationSet()
+	}
+
+	res := NewMutationSet(local.Namespace, revision, "")
+
+	for _, mut := range local.SortedMutations() {
+		new_mut := &Mutation{
+			Name:      mut.Name,
+			Needs:     ExplicitNeeds(mut.Name, mut.Needs),
+			Sql:       mut.Sql,
+			MetaNeeds: ExplicitNeeds(mut.Name, mut.MetaNeeds),
+			Meta:      mut.Meta,
+			Roles:     mut.Roles,
+			AllowDown: mut.AllowDown,
+			Timeout:   mut.Timeout,
+
+			NoTransaction: mut.NoTransaction,
+		}
+
+		if prev, ok := previous.GetMutation(mut.Name); ok {
+			if prev.SqlHash() != mut.SqlHash() {
+				new_mut.Sql = prev.Sql
+				new_mut.NewSql = append([]MutationStatement{}, mut.Sql...)
+			}
+			if !sameNeeds(mut.Name, prev.Needs, mut.Needs) {
+				new_mut.Needs = ExplicitNeeds(prev.Name, prev.Needs)
+				new_mut.NewNeeds = append([]string{}, ExplicitNeeds(mut.Name, mut.Needs)...)
+			}
+		}
+
+		if err := res.AddMutation(new_mut); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, prev := range previous.SortedMutations() {
+		if res.HasMutation(prev.Name) {
+			continue
+		}
+		if err := res.AddMutation(&Mutation{
+			Name:      prev.Name,
+			Needs:     ExplicitNeeds(prev.Name, prev.Needs),
+			Sql:       prev.Sql,
+			Roles:     prev.Roles,
+			AllowDown: prev.AllowDown,
+			Timeout:   prev.Timeout,
+			NewNeeds:  []string{},
+			NewSql:    []MutationStatement{},
+
+			NoTransaction: prev.NoTransaction,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := res.ResolveDependencies(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
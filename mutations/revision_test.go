@@ -0,0 +1,103 @@
+package mutations
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// A revision that only retires mutations still has overrides, so that the next revision drops them.
+func TestCreateRevisionRetirement(t *testing.T) {
+	previous := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+legacy:
+  sql:
+    - create schema legacy;
+`)
+	local := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+`)
+
+	revision, err := CreateRevision(previous, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revision.HasOverrides {
+		t.Fatalf("expected the retirement of legacy to be an override")
+	}
+	if !revision.HasMutation("legacy") {
+		t.Errorf("expected legacy to be kept in the revision that retires it")
+	}
+
+	// the next revision forgets legacy without dropping its schema
+	next := revision.ForRevision(revision.Revision + 1)
+	if mut, ok := next.GetMutation("legacy"); !ok || len(mut.Sql) > 0 {
+		t.Fatalf("expected legacy to have no sql in the next revision")
+	}
+	plan := NewMutationPlan(local, next)
+	for runnable := range plan.Runnables() {
+		t.Errorf("expected nothing to run, got %s", runnable.DisplayName())
+	}
+}
+
+// The keys of the mutations other than their sql and needs are kept by the revision and written with it.
+func TestCreateRevisionKeys(t *testing.T) {
+	previous := loadYamlString(t, `
+auth:
+  sql:
+    - create schema auth;
+users_email:
+  sql:
+    - create index concurrently users_email on users (email);
+`)
+	local := loadYamlString(t, `
+auth:
+  roles: [reader]
+  allow_down: true
+  timeout: 5m
+  sql:
+    - create schema auth2;
+users_email:
+  timeout: 1h
+  transaction: false
+  sql:
+    - create index concurrently users_email on users (email);
+`)
+
+	revision, err := CreateRevision(previous, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := revision.WriteYaml(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "revision.yml")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := LoadYamlMutations(file)
+	if err != nil {
+		t.Fatalf("error reading back the revision: %v\n%s", err, buf.String())
+	}
+	set := read.Latest("")
+
+	auth, _ := set.GetMutation("auth")
+	if !slices.Equal(auth.Roles, []string{"reader"}) || !auth.AllowDown || auth.Timeout != 5*time.Minute {
+		t.Errorf("expected auth to keep its roles, allow_down and timeout, got %v %v %v", auth.Roles, auth.AllowDown, auth.Timeout)
+	}
+	if len(auth.NewSql) != 1 {
+		t.Errorf("expected the changed sql of auth in new_sql, got %v", auth.NewSql)
+	}
+	index, _ := set.GetMutation("users_email")
+	if !index.NoTransaction || index.Timeout != time.Hour {
+		t.Errorf("expected users_email to keep transaction: false and its timeout, got %v %v", index.NoTransaction, index.Timeout)
+	}
+}
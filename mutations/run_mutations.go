@@ -1,6 +1,11 @@
 package mutations
 
 import (
+	"context"
+	"path"
+	"slices"
+	"time"
+
 	au "github.com/logrusorgru/aurora"
 	"github.com/samber/oops"
 )
@@ -10,6 +15,32 @@ type MutationRunnerOptions struct {
 	Commit   bool
 	Override bool
 	All      bool
+
+	// Refuse to down sql, unless the mutation has allow_down or matches one of AllowSqlDown.
+	GuardSqlDown bool
+	AllowSqlDown []string
+
+	// Called with the plan of every mutation set before it is run.
+	OnPlan func(plan *MutationPlan)
+
+	// Template variables the mutations are rendered with.
+	Vars map[string]any
+
+	// Namespaces to apply, all of them when empty.
+	Namespaces []string
+
+	// Selectors of the mutations to apply, the others are left as they are in the database.
+	Only    []string
+	Exclude []string
+
+	// How long to wait for another dmut applying the same namespaces and for the locks of the statements,
+	// and how long a statement may run. Both are left to the server's configuration when 0.
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+
+	// Commit every step in its own transaction, and resume an apply that was interrupted.
+	Phased bool
+	Resume bool
 }
 
 func (o *MutationRunnerOptions) Merge(others ...*MutationRunnerOptions) {
@@ -18,35 +49,69 @@ func (o *MutationRunnerOptions) Merge(others ...*MutationRunnerOptions) {
 		o.Commit = o.Commit || other.Commit
 		o.Override = o.Override || other.Override
 		o.All = o.All || other.All
+		o.Phased = o.Phased || other.Phased
+		o.Resume = o.Resume || other.Resume
+		o.GuardSqlDown = o.GuardSqlDown || other.GuardSqlDown
+		o.AllowSqlDown = append(o.AllowSqlDown, other.AllowSqlDown...)
+		if other.OnPlan != nil {
+			o.OnPlan = other.OnPlan
+		}
+		if other.Vars != nil {
+			o.Vars = other.Vars
+		}
+		o.Namespaces = append(o.Namespaces, other.Namespaces...)
+		o.Only = append(o.Only, other.Only...)
+		o.Exclude = append(o.Exclude, other.Exclude...)
+		if other.LockTimeout != 0 {
+			o.LockTimeout = other.LockTimeout
+		}
+		if other.StatementTimeout != 0 {
+			o.StatementTimeout = other.StatementTimeout
+		}
 	}
 }
 
 // RunMutations runs the mutations for a given local mutation set. A transaction should be started before calling this function.
-func RunMutations(runner Executor, local *MutationSet, opts ...*MutationRunnerOptions) error {
+// The sql of the mutations with transaction: false is recorded as pending and returned, for RunDeferred to run it once
+// the transaction was committed.
+func RunMutations(ctx context.Context, runner Executor, local *MutationSet, opts ...*MutationRunnerOptions) ([]*Runnable, error) {
 
 	var options = MutationRunnerOptions{}
 	options.Merge(opts...)
 
-	var err error
+	var deferred []*Runnable
 	has_changes := true
+	var history *History
+
+	local, ok, err := selectMutations(ctx, runner, local, &options)
+	if err != nil || !ok {
+		return nil, err
+	}
 
 	if !options.Override {
 
-		var namespace = local.Namespace
 		var distant *MutationSet
-
-		if distant, err = runner.GetDBMutationsFromDb(namespace); err != nil {
-			return err
+		if distant, err = getDistant(ctx, runner, local); err != nil {
+			return nil, err
+		}
+		if distant, err = resumePending(ctx, runner, distant); err != nil {
+			return nil, err
 		}
 
-		if distant.HasOverrides && distant.Revision < local.Revision {
-			runner.Logger().Println("using new_* mutations from previous revision")
-			distant = distant.AsNewMutationSet()
+		plan := NewMutationPlan(local, distant)
+		has_changes = plan.HasChanges()
+		if has_changes {
+			history = NewHistory(local.Namespace, distant.Revision, local.Revision)
+		}
+		if options.OnPlan != nil {
+			options.OnPlan(plan)
 		}
 
-		sql_down, sql_up := local.GetMutationsDelta(distant, ITER_SQL)
-		meta_down, meta_up := local.GetMutationsDelta(distant, ITER_META)
-		has_changes = sql_up.Size() != 0 || meta_up.Size() != 0 || sql_down.Size() != 0 || meta_down.Size() != 0
+		if options.GuardSqlDown {
+			if err := checkSqlDowns(runner, local, plan, options.AllowSqlDown); err != nil {
+				return nil, err
+			}
+		}
 
 		if !has_changes {
 			// No changes, no tests !
@@ -54,130 +119,323 @@ func RunMutations(runner Executor, local *MutationSet, opts ...*MutationRunnerOp
 		} else {
 			runner.Logger().Println(au.BrightGreen("→"), "applying mutations for namespace", au.BrightMagenta(local.Namespace).String(), "revision", au.BrightGreen(local.Revision).String())
 
-			if sql_down.Size() > 0 {
-				var fake_empty_local_set *MutationSet = nil
-				_, meta_up = local.GetMutationsDelta(nil, ITER_META)
-				meta_down, _ = fake_empty_local_set.GetMutationsDelta(distant, ITER_META)
-			}
-
-			// 1. Start by downing the meta
-			if err := meta_down.Run(runner); err != nil {
-				return err
+			if err := plan.Run(ctx, &historyRecorder{Executor: runner, history: history}); err != nil {
+				return nil, err
 			}
+		}
 
-			if err := sql_down.Run(runner); err != nil {
-				return err
-			}
+		deferred = plan.Deferred.Values()
+		for _, runnable := range deferred {
+			runnable.Mutation.Pending = true
+		}
+	} else {
+		db_mutations, err := runner.GetDBMutationsFromDb(ctx, local.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		history = NewHistory(local.Namespace, db_mutations.Revision, local.Revision)
+		history.Override = true
+	}
 
-			if err := sql_up.Run(runner); err != nil {
-				return err
-			}
+	if err := runner.SaveMutations(ctx, local); err != nil {
+		return nil, err
+	}
 
-			if err := meta_up.Run(runner); err != nil {
-				return err
-			}
+	if history != nil {
+		if err := runner.SaveHistory(ctx, history); err != nil {
+			return nil, err
 		}
-
 	}
 
-	if err := runner.SaveMutations(local); err != nil {
-		return err
+	// the mutations outside of the transaction did not run yet, and nothing needs them
+	testable, err := local.filter(func(mut *Mutation) bool { return !mut.NoTransaction })
+	if err != nil {
+		return nil, err
 	}
 
 	if has_changes {
 		runner.Logger().Println(au.BrightGreen("🧪"), "performing tests")
-		if err := TestMutationSet(runner, local); err != nil {
-			return err
+		if err := TestMutationSet(ctx, runner, testable); err != nil {
+			return nil, err
 		}
 	}
 
-	if local.HasOverrides {
-		local2 := local.AsNewMutationSet()
+	if testable.HasOverrides {
+		local2 := testable.AsNewMutationSet()
 		runner.Logger().Println(au.BrightGreen("🧪"), "performing tests with new_*")
-		if err := TestMutationSet(runner, local2); err != nil {
-			return err
+		if err := TestMutationSet(ctx, runner, local2); err != nil {
+			return nil, err
 		}
 	}
 	runner.Logger().Println(au.BrightGreen("✓"), "tests passed")
 
-	return nil
+	return deferred, nil
 }
 
-func RunAllMutations(runner Executor, namespaces *MutationNamespace, opts ...*MutationRunnerOptions) (err error) {
+// selectMutations applies --only and --exclude to the local set. It returns false when nothing was selected.
+func selectMutations(ctx context.Context, runner Executor, local *MutationSet, options *MutationRunnerOptions) (*MutationSet, bool, error) {
+	selection := NewSelection(options.Only, options.Exclude)
+	if selection.IsEmpty() {
+		return local, true, nil
+	}
 
-	var options = MutationRunnerOptions{}
-	options.Merge(opts...)
+	distant, err := getDistant(ctx, runner, local)
+	if err != nil {
+		return nil, false, err
+	}
+	selected, ok, err := selection.Select(local, distant)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		runner.Logger().Println(au.BrightGreen("≡"), "no selected mutations in namespace", au.BrightMagenta(local.Namespace).String())
+		return nil, false, nil
+	}
+	return selected, true, nil
+}
 
-	if err := runner.Begin(); err != nil {
-		return err
+// getDistant returns the mutations the database holds, as seen from the revision of the local set.
+func getDistant(ctx context.Context, runner Executor, local *MutationSet) (*MutationSet, error) {
+	distant, err := runner.GetDBMutationsFromDb(ctx, local.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := distant.CheckHashes(); err != nil {
+		return nil, err
 	}
 
-	for _, namespace := range namespaces.Keys() {
-		db_mutations, err := runner.GetDBMutationsFromDb(namespace)
-		if err != nil {
-			return err
-		}
+	if distant.HasOverrides && distant.Revision < local.Revision {
+		runner.Logger().Println("using new_* mutations from previous revision")
+	}
+	return distant.ForRevision(local.Revision), nil
+}
 
-		revisions, ok := namespaces.Map.Get(namespace)
-		if !ok {
-			return oops.In("mutations").With("namespace", namespace).Errorf("no revision sequence found")
+// withoutPending returns the set without the mutations whose sql did not run outside of the transaction.
+func (ms *MutationSet) withoutPending() (*MutationSet, error) {
+	return ms.filter(func(mut *Mutation) bool { return !mut.Pending })
+}
+
+// resumePending downs what the pending mutations may have left behind, such as an invalid index, so that
+// they are run again as new mutations. The statements whose object does not exist are skipped.
+func resumePending(ctx context.Context, runner Executor, distant *MutationSet) (*MutationSet, error) {
+	var has_pending = false
+	for mut := range distant.AllMutations() {
+		if !mut.Pending {
+			continue
 		}
+		has_pending = true
 
-		// Db has no mutations, only apply the highest local one
-		if db_mutations.Revision == 0 {
-			if !options.All {
-				runner.Logger().Println(au.BrightGreen("→"), "no database mutations,applying highest local revision for namespace", namespace)
-				if revision, ok := revisions.Revisions[revisions.MaxRevision]; ok {
-					if err := RunMutations(runner, revision, opts...); err != nil {
-						return err
-					}
+		runnable := mut.Runnable(ITER_SQL_DOWN)
+		runner.Logger().Println(au.BrightYellow("↻"), "resuming pending", runnable.DisplayName())
+		for _, stmt := range runnable.Statements() {
+			if err := runner.SavePoint(ctx, "pending_down"); err != nil {
+				return nil, err
+			}
+			if err := runner.Exec(ctx, stmt); err != nil {
+				if !IsMissingObject(err) {
+					return nil, oops.With("runnable", runnable.DisplayName()).Wrap(err)
 				}
-				continue
-			} else {
-				// db revision cannot be less than 1
-				db_mutations.Revision = 1
+				if err := runner.RollbackToSavepoint(ctx, "pending_down"); err != nil {
+					return nil, err
+				}
+			}
+			if err := runner.ReleaseSavepoint(ctx, "pending_down"); err != nil {
+				return nil, err
 			}
 		}
+	}
 
-		for i := db_mutations.Revision; i <= revisions.MaxRevision; i++ {
-			if revision, ok := revisions.Revisions[i]; ok {
-				if err := RunMutations(runner, revision, opts...); err != nil {
-					return err
-				}
-			}
+	if !has_pending {
+		return distant, nil
+	}
+	return distant.withoutPending()
+}
+
+// RunDeferred runs, outside of any transaction, the sql of the mutations with transaction: false, recording each one as
+// applied once it ran. A failure leaves the mutation pending, the next apply downs it and runs it again.
+func RunDeferred(ctx context.Context, runner Executor, deferred []*Runnable) error {
+	for _, runnable := range deferred {
+		start := time.Now()
+		if err := runner.Run(ctx, runnable); err != nil {
+			return oops.Hint("the mutation is left pending, and will be run again by the next apply").Wrap(err)
+		}
+		if err := runner.MarkApplied(ctx, runnable.Mutation); err != nil {
+			return err
 		}
+		if err := runner.AppendHistory(ctx, runnable.Mutation.Namespace, newHistoryRun(runnable, time.Since(start))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSqlDowns refuses the plan if it downs the sql of mutations that were not explicitly allowed to be downed.
+func checkSqlDowns(runner Executor, local *MutationSet, plan *MutationPlan, patterns []string) error {
+	var refused []string
+
+	for _, runnable := range plan.SqlDown.Values() {
+		if runnable.IsEmpty() {
+			continue
+		}
+		if mut, ok := local.GetMutation(runnable.Mutation.Name); ok && mut.AllowDown {
+			continue
+		}
+		if slices.ContainsFunc(patterns, func(pattern string) bool {
+			matched, _ := path.Match(pattern, runnable.Mutation.Name)
+			return matched
+		}) {
+			continue
+		}
+
+		runner.Logger().Println(au.BrightRed("✗"), runnable.DisplayName())
+		refused = append(refused, runnable.Mutation.Name)
+	}
+
+	if len(refused) > 0 {
+		return oops.In("mutations").
+			With("namespace", local.Namespace).
+			With("mutations", refused).
+			Hint("use --allow-sql-down=<pattern> or set allow_down: true on the mutations").
+			Errorf("refusing to down the sql of %d mutations, data could be lost", len(refused))
+	}
+	return nil
+}
+
+func RunAllMutations(ctx context.Context, runner Executor, namespaces *MutationNamespace, opts ...*MutationRunnerOptions) (err error) {
+
+	var options = MutationRunnerOptions{}
+	options.Merge(opts...)
+
+	if options.Phased || options.Resume {
+		return runAllPhased(ctx, runner, namespaces, &options, opts...)
+	}
+
+	if err := runner.Begin(ctx); err != nil {
+		return err
+	}
+
+	// the transaction is rolled back and the locks released on errors, including a cancelled context
+	abort := func(err error) error {
+		if err2 := runner.Rollback(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
+		if err2 := runner.Unlock(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
+		return err
+	}
+
+	deferred, err := runAllNamespaces(ctx, runner, namespaces, &options, opts...)
+	if err != nil {
+		return abort(err)
 	}
 
 	runner.Logger().Println(au.BrightGreen("🎉"), "no errors")
 	if options.Commit {
 		// runner.Logger().Println(au.BrightGreen("💾"), "committing")
-		if err := runner.Commit(); err != nil {
-			return err
+		if err := runner.Commit(ctx); err != nil {
+			return abort(err)
 		}
 	} else {
-		if err := runner.Rollback(); err != nil {
+		if err := runner.Rollback(ctx); err != nil {
 			return err
 		}
+		if len(deferred) > 0 {
+			runner.Logger().Println(au.BrightYellow("⚠"), len(deferred), "mutations outside of the transaction were not run")
+		}
+		return runner.Unlock(ctx)
 	}
 
-	return nil
+	// the locks are still held, so that nobody applies the namespaces while the deferred sql runs
+	if err := RunDeferred(ctx, runner, deferred); err != nil {
+		if err2 := runner.Unlock(ctx); err2 != nil {
+			runner.Logger().Println(au.BrightRed("✗"), err2)
+		}
+		return err
+	}
+
+	return runner.Unlock(ctx)
+}
+
+// runAllNamespaces locks and applies dmut's own namespace, then all the others, and drops the roles that no namespace
+// declares anymore. It returns the runnables that have
+// to run once the transaction was committed.
+func runAllNamespaces(ctx context.Context, runner Executor, namespaces *MutationNamespace, options *MutationRunnerOptions, opts ...*MutationRunnerOptions) ([]*Runnable, error) {
+
+	var deferred []*Runnable
+
+	// the locks are all taken before the timeouts are set, as statement_timeout would cancel the wait
+	for _, namespace := range append([]string{DMUT_NAMESPACE}, namespaces.Namespaces()...) {
+		if err := runner.Lock(ctx, namespace, options.LockTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if err := runner.SetTimeouts(ctx, options.StatementTimeout, options.LockTimeout); err != nil {
+		return nil, err
+	}
+
+	// the tracking table has to be up to date before anything is saved in it
+	if err := Bootstrap(ctx, runner, opts...); err != nil {
+		return nil, err
+	}
+	// roles are shared by the namespaces, they are only dropped once none of them declares them anymore
+	declared_roles, err := runner.DeclaredRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, namespace := range namespaces.Namespaces() {
+		db_mutations, err := runner.GetDBMutationsFromDb(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions, ok := namespaces.Map.Get(namespace)
+		if !ok {
+			return nil, oops.In("mutations").With("namespace", namespace).Errorf("no revision sequence found")
+		}
+
+		if db_mutations.Revision == 0 && !options.All {
+			runner.Logger().Println(au.BrightGreen("→"), "no database mutations,applying highest local revision for namespace", namespace)
+		}
+
+		// the pending mutations of a revision are resumed by the next one, only the last one's are kept
+		var namespace_deferred []*Runnable
+		for _, revision := range revisions.RevisionsToApply(db_mutations.Revision, options.All) {
+			if namespace_deferred, err = RunMutations(ctx, runner, revision, opts...); err != nil {
+				return nil, err
+			}
+		}
+		deferred = append(deferred, namespace_deferred...)
+	}
+
+	if err := runner.DropRoles(ctx, declared_roles); err != nil {
+		return nil, err
+	}
+	return deferred, nil
 }
 
-func ReadAndRunMutations(uri string, paths []string, opts MutationRunnerOptions) error {
+func ReadAndRunMutations(ctx context.Context, uri string, paths []string, opts MutationRunnerOptions) error {
 
-	muts, err := LoadYamlMutations(paths...)
+	muts, err := LoadYamlMutationsWithVars(opts.Vars, paths...)
 	if err != nil {
 		return err
 	}
 
-	runner, err := NewPgRunner(uri, opts.Verbose)
+	if len(opts.Namespaces) > 0 {
+		if err := muts.KeepNamespaces(opts.Namespaces); err != nil {
+			return err
+		}
+	}
+
+	runner, err := NewPgRunner(ctx, uri, opts.Verbose)
 	if err != nil {
 		return err
 	}
 	defer runner.Close()
 
 	// Test before
-	if err := RunAllMutations(runner, muts, &opts); err != nil {
+	if err := RunAllMutations(ctx, runner, muts, &opts); err != nil {
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package mutations
 
 import (
+	"context"
 	"fmt"
 	"iter"
 
@@ -20,6 +21,12 @@ func (r *Runnable) DisplayName() string {
 	return fmt.Sprintf("%s %s·%s", r.Direction.UpOrDown(), r.Mutation.DisplayName(), r.Direction.MetaOrSql())
 }
 
+// InTransaction is false for the sql up of the mutations with transaction: false. Their down is
+// run in the transaction like any other.
+func (r *Runnable) InTransaction() bool {
+	return !r.Mutation.NoTransaction || r.Direction.Down || r.Direction.Meta
+}
+
 func (r *Runnable) Size() int {
 	if r.Direction.Meta {
 		return len(r.Mutation.Meta)
@@ -64,9 +71,9 @@ func NewRunnableMap() *RunnableMap {
 	}
 }
 
-func (rm *RunnableMap) Run(runner Executor) error {
+func (rm *RunnableMap) Run(ctx context.Context, runner Executor) error {
 	for _, runnable := range rm.Values() {
-		if err := runner.Run(runnable); err != nil {
+		if err := runner.Run(ctx, runnable); err != nil {
 			return err
 		}
 	}
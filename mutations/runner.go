@@ -1,7 +1,9 @@
 package mutations
 
 import (
+	"context"
 	"log"
+	"time"
 )
 
 type Executor interface {
@@ -9,20 +11,37 @@ type Executor interface {
 	ResumeLogging()
 	SetTesting()
 	GetStringOutput() string
-	Exec(sql string, args ...interface{}) error
+	Exec(ctx context.Context, sql string, args ...interface{}) error
 
-	Begin() error
-	Rollback() error
-	Commit() error
-	SavePoint(name string) error
-	RollbackToSavepoint(name string) error
-	ReleaseSavepoint(name string) error
+	Begin(ctx context.Context) error
+	Rollback(ctx context.Context) error
+	Commit(ctx context.Context) error
+	SavePoint(ctx context.Context, name string) error
+	RollbackToSavepoint(ctx context.Context, name string) error
+	ReleaseSavepoint(ctx context.Context, name string) error
+	SetTimeouts(ctx context.Context, statement_timeout time.Duration, lock_timeout time.Duration) error
 
-	GetDBMutationsFromDb(namespace string) (*MutationSet, error)
+	Lock(ctx context.Context, namespace string, timeout time.Duration) error
+	Unlock(ctx context.Context) error
 
-	ClearMutations(namespace string) error
-	SaveMutations(mutations *MutationSet) error
+	GetDBMutationsFromDb(ctx context.Context, namespace string) (*MutationSet, error)
+	GetDBNamespaces(ctx context.Context) ([]string, error)
 
-	Run(runnable *Runnable) error
+	CreateMissingRoles(ctx context.Context, roles []string) error
+	DeclaredRoles(ctx context.Context) ([]string, error)
+	DropRoles(ctx context.Context, roles []string) error
+
+	ClearMutations(ctx context.Context, namespace string) error
+	SaveMutations(ctx context.Context, mutations *MutationSet) error
+	MarkApplied(ctx context.Context, mutation *Mutation) error
+
+	GetProgress(ctx context.Context, namespace string) (*Progress, error)
+	SaveProgress(ctx context.Context, progress *Progress) error
+	ClearProgress(ctx context.Context, namespace string) error
+
+	SaveHistory(ctx context.Context, history *History) error
+	AppendHistory(ctx context.Context, namespace string, runs ...*HistoryRun) error
+
+	Run(ctx context.Context, runnable *Runnable) error
 	Close() error
 }
@@ -0,0 +1,195 @@
+package mutations
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/samber/oops"
+	"github.com/ugurcsen/gods-generic/sets/hashset"
+)
+
+// Selector picks mutations by namespace (namespace:<glob>), by file (file:<glob or directory>, relative
+// to the paths the mutations were read from), or by name glob (api.users.*).
+type Selector struct {
+	Kind    string
+	Pattern string
+}
+
+func ParseSelector(str string) Selector {
+	for _, kind := range []string{"namespace", "file"} {
+		if pattern, ok := strings.CutPrefix(str, kind+":"); ok {
+			return Selector{Kind: kind, Pattern: pattern}
+		}
+	}
+	return Selector{Kind: "name", Pattern: str}
+}
+
+func (s Selector) String() string {
+	if s.Kind == "name" {
+		return s.Pattern
+	}
+	return s.Kind + ":" + s.Pattern
+}
+
+func globMatches(pattern string, value string) bool {
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+func (s Selector) Matches(mut *Mutation) bool {
+	switch s.Kind {
+	case "namespace":
+		return globMatches(s.Pattern, mut.Namespace)
+	case "file":
+		var dir = strings.TrimSuffix(path.Clean(s.Pattern), "/")
+		return globMatches(path.Clean(s.Pattern), mut.File) || strings.HasPrefix(mut.File, dir+"/")
+	default:
+		return globMatches(s.Pattern, mut.Name)
+	}
+}
+
+// Selection keeps the mutations that match any of Only, or all of them when Only is empty,
+// and that match none of Exclude.
+type Selection struct {
+	Only    []Selector
+	Exclude []Selector
+}
+
+func NewSelection(only []string, exclude []string) Selection {
+	var res Selection
+	for _, str := range only {
+		res.Only = append(res.Only, ParseSelector(str))
+	}
+	for _, str := range exclude {
+		res.Exclude = append(res.Exclude, ParseSelector(str))
+	}
+	return res
+}
+
+func (sel Selection) IsEmpty() bool {
+	return len(sel.Only) == 0 && len(sel.Exclude) == 0
+}
+
+// String gives the selection as it is written on the command line.
+func (sel Selection) String() string {
+	var res []string
+	for _, s := range sel.Only {
+		res = append(res, "--only="+s.String())
+	}
+	for _, s := range sel.Exclude {
+		res = append(res, "--exclude="+s.String())
+	}
+	return strings.Join(res, " ")
+}
+
+func (sel Selection) Selects(mut *Mutation) bool {
+	var selected = len(sel.Only) == 0
+	for _, s := range sel.Only {
+		selected = selected || s.Matches(mut)
+	}
+	for _, s := range sel.Exclude {
+		selected = selected && !s.Matches(mut)
+	}
+	return selected
+}
+
+// clone copies a mutation without its resolved dependencies, so that it can be part of another set.
+func (mut *Mutation) clone() *Mutation {
+	return &Mutation{
+		Name:      mut.Name,
+		File:      mut.File,
+		Namespace: mut.Namespace,
+		Needs:     ExplicitNeeds(mut.Name, mut.Needs),
+		Sql:       mut.Sql,
+		MetaNeeds: ExplicitNeeds(mut.Name, mut.MetaNeeds),
+		Meta:      mut.Meta,
+		Roles:     mut.Roles,
+		AllowDown: mut.AllowDown,
+		Timeout:   mut.Timeout,
+		NewNeeds:  mut.NewNeeds,
+		NewSql:    mut.NewSql,
+
+		NoTransaction: mut.NoTransaction,
+		Pending:       mut.Pending,
+
+		StoredSqlHash:  mut.StoredSqlHash,
+		StoredMetaHash: mut.StoredMetaHash,
+		HashVersion:    mut.HashVersion,
+	}
+}
+
+// Select returns the set that the database should hold once only the selected mutations were applied :
+// the selected local mutations and the parents they need, the distant ones for everything else.
+// Selected mutations that are only in the distant set are removed. It returns false when nothing was selected.
+func (sel Selection) Select(local *MutationSet, distant *MutationSet) (*MutationSet, bool, error) {
+	var selected = hashset.New[string]()
+	for mut := range local.AllMutations() {
+		if !sel.Selects(mut) {
+			continue
+		}
+		for _, dir := range []IterationDirection{ITER_SQL, ITER_META} {
+			for dep := range mut.IterateDependencies(dir) {
+				selected.Add(dep.Name)
+			}
+		}
+	}
+
+	var removed = hashset.New[string]()
+	for mut := range distant.AllMutations() {
+		if _, ok := local.GetMutation(mut.Name); !ok && sel.Selects(mut) {
+			removed.Add(mut.Name)
+		}
+	}
+
+	if selected.Size() == 0 && removed.Size() == 0 {
+		return nil, false, nil
+	}
+
+	// the mutations left as they are cannot lose a parent
+	var dependents []string
+	for _, mut := range distant.SortedMutations() {
+		if selected.Contains(mut.Name) || removed.Contains(mut.Name) {
+			continue
+		}
+		var needs []string
+		for _, need := range slices.Concat(ImplicitParents(mut.Name), mut.Needs, mut.MetaNeeds) {
+			if removed.Contains(need) && !slices.Contains(needs, need) {
+				needs = append(needs, need)
+			}
+		}
+		if len(needs) > 0 {
+			dependents = append(dependents, fmt.Sprintf("%s needs %s", mut.Name, strings.Join(needs, ", ")))
+		}
+	}
+	if len(dependents) > 0 {
+		return nil, false, oops.In("selection").
+			With("namespace", local.Namespace).
+			With("selection", sel.String()).
+			With("dependents", dependents).
+			Hint("select the dependents as well, or leave the removed mutations out of the selection").
+			Errorf("%s removes mutations that unselected ones need: %s", sel.String(), strings.Join(dependents, "; "))
+	}
+
+	res := NewMutationSet(local.Namespace, local.Revision, local.File)
+	res.vars = local.vars
+	for mut := range distant.AllMutations() {
+		if !selected.Contains(mut.Name) && !removed.Contains(mut.Name) {
+			if err := res.AddMutation(mut.clone()); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	for _, name := range selected.Values() {
+		mut, _ := local.GetMutation(name)
+		if err := res.AddMutation(mut.clone()); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := res.ResolveDependencies(); err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
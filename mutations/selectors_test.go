@@ -0,0 +1,142 @@
+package mutations
+
+import (
+	"strings"
+	"testing"
+)
+
+// Unselected mutations keep the definition the database has, so that applying a selection does not touch them.
+func TestSelectionLeavesOthersUntouched(t *testing.T) {
+	distant := loadYamlString(t, `
+api:
+  sql:
+    - create schema api;
+api.users:
+  sql:
+    - create table api.users (id int);
+billing:
+  sql:
+    - create schema billing;
+  meta:
+    - grant usage on schema billing to public;
+legacy:
+  sql:
+    - create schema legacy;
+`)
+	local := loadYamlString(t, `
+api:
+  sql:
+    - create schema api;
+api.users:
+  sql:
+    - create table api.users (id int, name text);
+api.users.email:
+  sql:
+    - alter table api.users add column email text;
+billing:
+  sql:
+    - create schema billing;
+  meta:
+    - grant usage on schema billing to billing_role;
+`)
+
+	selection := NewSelection([]string{"api.users.*"}, nil)
+	selected, ok, err := selection.Select(local, distant)
+	if err != nil || !ok {
+		t.Fatalf("expected a selection, got %v %v", ok, err)
+	}
+
+	for name, want := range map[string]*MutationSet{
+		"api":             local,
+		"api.users":       local,
+		"api.users.email": local,
+		"billing":         distant,
+		"legacy":          distant,
+	} {
+		mut, ok := selected.GetMutation(name)
+		if !ok {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+		expected, _ := want.GetMutation(name)
+		if mut.SqlHash() != expected.SqlHash() || mut.MetaHash() != expected.MetaHash() {
+			t.Errorf("%s does not have the expected definition", name)
+		}
+	}
+
+	plan := NewMutationPlan(selected, distant)
+	for _, rm := range []*RunnableMap{plan.SqlDown, plan.SqlUp} {
+		for _, name := range rm.Keys() {
+			if name != "api.users" && name != "api.users.email" {
+				t.Errorf("%s should not be run", name)
+			}
+		}
+	}
+
+	if _, ok, _ := NewSelection([]string{"namespace:other"}, nil).Select(local, distant); ok {
+		t.Errorf("nothing should be selected in another namespace")
+	}
+
+	// removed mutations are only removed when selected
+	if selected, _, _ := NewSelection(nil, []string{"legacy"}).Select(local, distant); !selected.HasMutation("legacy") {
+		t.Errorf("legacy is excluded and should be kept")
+	}
+	if selected, _, _ := NewSelection([]string{"legacy"}, nil).Select(local, distant); selected.HasMutation("legacy") {
+		t.Errorf("legacy is selected and should be removed")
+	}
+}
+
+// Removing a mutation that an unselected one still needs is refused, naming the mutations and the selection.
+func TestSelectionRemovedDependents(t *testing.T) {
+	distant := loadYamlString(t, `
+legacy:
+  sql:
+    - create schema legacy;
+legacy.users:
+  sql:
+    - create table legacy.users (id int);
+reports:
+  needs: [legacy.users]
+  meta:
+    - create view public.reports as select * from legacy.users;
+`)
+	local := loadYamlString(t, `
+reports:
+  meta:
+    - create view public.reports as select 1;
+`)
+
+	_, _, err := NewSelection([]string{"legacy*"}, nil).Select(local, distant)
+	if err == nil {
+		t.Fatalf("expected an error, reports needs legacy.users")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "reports needs legacy.users") || !strings.Contains(msg, "--only=legacy*") {
+		t.Errorf("expected the error to name reports and the selection, got %s", msg)
+	}
+
+	// selected along with what needs it, everything goes
+	if _, ok, err := NewSelection([]string{"legacy*", "reports"}, nil).Select(local, distant); err != nil || !ok {
+		t.Errorf("expected a selection, got %v %v", ok, err)
+	}
+}
+
+func TestKeepNamespaces(t *testing.T) {
+	muts, err := LoadYamlMutations("test/test.yml", "../test/revision")
+	if err != nil {
+		t.Fatalf("error loading mutations: %v", err)
+	}
+	if err := muts.KeepNamespaces([]string{"unknown"}); err == nil {
+		t.Errorf("expected an error for an unknown namespace")
+	}
+
+	var names = muts.Namespaces()
+	if len(names) < 2 {
+		t.Fatalf("expected several namespaces, got %v", names)
+	}
+	if err := muts.KeepNamespaces([]string{names[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if kept := muts.Namespaces(); len(kept) != 1 || kept[0] != names[1] {
+		t.Errorf("expected %s, got %v", names[1], kept)
+	}
+}
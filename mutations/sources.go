@@ -0,0 +1,55 @@
+package mutations
+
+import (
+	"context"
+	"os"
+)
+
+// LoadDBMutations reads the mutations of every namespace saved in the database.
+func LoadDBMutations(ctx context.Context, uri string) (*MutationNamespace, error) {
+	runner, err := NewPgRunner(ctx, uri, false)
+	if err != nil {
+		return nil, err
+	}
+	defer runner.Close()
+
+	return runner.GetDBMutationNamespaces(ctx)
+}
+
+// GetDBMutationNamespaces reads the mutations of every namespace saved in the database.
+func (r *PgRunner) GetDBMutationNamespaces(ctx context.Context) (*MutationNamespace, error) {
+	namespaces, err := r.GetDBNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := NewMutationNamespace()
+	for _, namespace := range namespaces {
+		set, err := r.GetDBMutationsFromDb(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if err := res.AddSet(set); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// LoadMutationSource reads mutations from a source that is either a yaml file, a directory, or a database uri.
+// The template variables are only used for yaml sources, as the database holds rendered statements.
+func LoadMutationSource(ctx context.Context, source string, vars map[string]any) (*MutationNamespace, error) {
+	if _, err := os.Stat(source); err == nil {
+		return LoadYamlMutationsWithVars(vars, source)
+	}
+	return LoadDBMutations(ctx, source)
+}
+
+// Latest returns the highest revision of a namespace, or nil if the namespace is unknown.
+func (ns *MutationNamespace) Latest(namespace string) *MutationSet {
+	revisions, ok := ns.Map.Get(namespace)
+	if !ok {
+		return nil
+	}
+	return revisions.Revisions[revisions.MaxRevision]
+}
@@ -0,0 +1,145 @@
+package mutations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+// NamespaceStatus tells how far the database is from the local files for a namespace.
+type NamespaceStatus struct {
+	Namespace     string
+	DbRevision    int
+	LocalRevision int
+
+	// The namespace is recorded in the database but is not in the files anymore.
+	OnlyInDb bool
+
+	// The database holds new_* overrides from a previous revision, which apply compares with instead.
+	UsesNew bool
+
+	// The differences between the database and the highest local revision, as apply would see them.
+	Diffs []*MutationDiff
+
+	// Mutations whose sql did not run outside of the transaction, and whether a phased apply was interrupted.
+	Pending     []string
+	Interrupted bool
+}
+
+func (s *NamespaceStatus) HasDrift() bool {
+	return s.OnlyInDb || s.DbRevision != s.LocalRevision || len(s.Diffs) > 0 || len(s.Pending) > 0 || s.Interrupted
+}
+
+// GetStatus compares the highest local revision of every namespace with what the database holds, and reports
+// the namespaces that only the database has.
+func GetStatus(ctx context.Context, runner Executor, namespaces *MutationNamespace) ([]*NamespaceStatus, error) {
+	var res []*NamespaceStatus
+	for _, namespace := range namespaces.Namespaces() {
+		status, err := getNamespaceStatus(ctx, runner, namespaces.Latest(namespace))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, status)
+	}
+
+	db_namespaces, err := runner.GetDBNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range db_namespaces {
+		if _, ok := namespaces.Map.Get(namespace); ok || namespace == DMUT_NAMESPACE {
+			continue
+		}
+		// compared with an empty set, all of its mutations show as removed
+		status, err := getNamespaceStatus(ctx, runner, NewMutationSet(namespace, 0, ""))
+		if err != nil {
+			return nil, err
+		}
+		status.OnlyInDb = true
+		res = append(res, status)
+	}
+	return res, nil
+}
+
+func getNamespaceStatus(ctx context.Context, runner Executor, local *MutationSet) (*NamespaceStatus, error) {
+	distant, err := runner.GetDBMutationsFromDb(ctx, local.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := distant.CheckHashes(); err != nil {
+		return nil, err
+	}
+
+	status := &NamespaceStatus{
+		Namespace:     local.Namespace,
+		DbRevision:    distant.Revision,
+		LocalRevision: local.Revision,
+		UsesNew:       distant.HasOverrides && distant.Revision < local.Revision,
+	}
+	for mut := range distant.AllMutations() {
+		if mut.Pending {
+			status.Pending = append(status.Pending, mut.Name)
+		}
+	}
+	status.Diffs = DiffSets(distant.ForRevision(local.Revision), local)
+
+	progress, err := runner.GetProgress(ctx, local.Namespace)
+	if err != nil && !IsMissingObject(err) {
+		return nil, err
+	}
+	status.Interrupted = progress != nil
+	return status, nil
+}
+
+// Print writes the revisions of the namespace, and the mutations that differ.
+func (s *NamespaceStatus) Print(w io.Writer) {
+	if !s.HasDrift() {
+		fmt.Fprintln(w, au.BrightGreen("≡"), "namespace", au.BrightMagenta(s.Namespace), "is up to date at revision", au.BrightGreen(s.LocalRevision))
+		return
+	}
+
+	if s.OnlyInDb {
+		fmt.Fprintln(w, au.BrightRed("≠"), "namespace", au.BrightMagenta(s.Namespace), "is at revision", au.BrightRed(s.DbRevision),
+			"in the database and is not in the files anymore")
+	} else {
+		var uses_new = ""
+		if s.UsesNew {
+			uses_new = fmt.Sprintf("(compared with its new_* from revision %d)", s.DbRevision)
+		}
+		fmt.Fprintln(w, au.BrightRed("≠"), "namespace", au.BrightMagenta(s.Namespace), "is at revision", au.BrightRed(s.DbRevision),
+			"in the database and", au.BrightGreen(s.LocalRevision), "in the files", uses_new)
+	}
+
+	if s.Interrupted {
+		fmt.Fprintln(w, "   ", au.BrightYellow("⚠"), "a phased apply was interrupted, use --resume")
+	}
+	for _, name := range s.Pending {
+		fmt.Fprintln(w, "   ", au.BrightYellow("↻"), name, au.Faint("pending"))
+	}
+	for _, diff := range s.Diffs {
+		var changes []string
+		switch {
+		case diff.IsAdded():
+			changes = append(changes, "new")
+		case diff.IsRemoved():
+			changes = append(changes, "removed")
+		default:
+			if diff.SqlChanged() {
+				changes = append(changes, "sql")
+			}
+			if diff.MetaChanged() {
+				changes = append(changes, "meta")
+			}
+			if !diff.Needs.IsEmpty() || !diff.MetaNeeds.IsEmpty() {
+				changes = append(changes, "needs")
+			}
+			if !diff.Roles.IsEmpty() {
+				changes = append(changes, "roles")
+			}
+		}
+		fmt.Fprintln(w, "   ", diff.DisplayName(), au.Faint(strings.Join(changes, ", ")))
+	}
+}
@@ -0,0 +1,50 @@
+package mutations
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A namespace removed from the files but still recorded in the database is reported as drift.
+func TestStatusOnlyInDb(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte("__namespace: shop\nbase:\n  sql:\n    - create schema base;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	namespaces, err := LoadYamlMutations(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	runner := newMemoryRunner()
+	if err := runner.SaveMutations(ctx, namespaces.Latest("shop")); err != nil {
+		t.Fatal(err)
+	}
+	old := NewMutationSet("old", 2, "")
+	old.AddMutation(&Mutation{Name: "legacy", Sql: []MutationStatement{{Up: "create schema legacy;", Down: "drop schema legacy;"}}})
+	if err := runner.SaveMutations(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := GetStatus(ctx, runner, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found *NamespaceStatus
+	for _, status := range statuses {
+		if status.Namespace == "old" {
+			found = status
+		} else if status.HasDrift() {
+			t.Errorf("expected %s to be up to date", status.Namespace)
+		}
+	}
+	if found == nil || !found.OnlyInDb || !found.HasDrift() {
+		t.Fatalf("expected old to be reported as only in the database, got %+v", found)
+	}
+	if found.DbRevision != 2 || len(found.Diffs) != 1 {
+		t.Errorf("expected revision 2 and legacy to show as removed, got %d and %d diffs", found.DbRevision, len(found.Diffs))
+	}
+}
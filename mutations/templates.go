@@ -2,6 +2,7 @@ package mutations
 
 import (
 	"bytes"
+	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
@@ -11,24 +12,49 @@ type DmutOptions struct {
 	DmutSchema string
 }
 
+// TemplateContext is what the statements of a mutation file see when they are rendered.
 type TemplateContext struct {
-	Options DmutOptions
+	Options   DmutOptions
+	Namespace string
+	Revision  int
+	Vars      map[string]any
 }
 
+func NewTemplateContext(namespace string, revision int, vars map[string]any) TemplateContext {
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	return TemplateContext{
+		Options:   DmutOptions{DmutSchema: DMUT_NAMESPACE},
+		Namespace: namespace,
+		Revision:  revision,
+		Vars:      vars,
+	}
+}
+
+// RunTemplate renders a statement. Referencing a variable that was not given is an error.
 func RunTemplate(templ string, context TemplateContext) (string, error) {
+	if !strings.Contains(templ, "{{") {
+		return templ, nil
+	}
+
 	sp := sprig.FuncMap()
 
-	tpl, err := template.New("").Funcs(sp).Parse(templ)
+	tpl, err := template.New("").Funcs(sp).Option("missingkey=error").Parse(templ)
 	if err != nil {
 		return "", err
 	}
 
 	var buf bytes.Buffer
 	if err := tpl.Execute(&buf, map[string]any{
-		"Options": context.Options,
+		"Options":    context.Options,
+		"DmutSchema": context.Options.DmutSchema,
+		"Namespace":  context.Namespace,
+		"Revision":   context.Revision,
+		"Vars":       context.Vars,
 	}); err != nil {
 		return "", err
 	}
-	return "", nil
+	return buf.String(), nil
 
 }
@@ -0,0 +1,85 @@
+package mutations
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Statements are rendered before their down is inferred, and unknown variables are errors.
+func TestTemplatedStatements(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte(`
+__namespace: tenant
+schema:
+  roles: ["{{ .Vars.prefix }}_reader"]
+  sql:
+    - create schema {{ .Vars.schema | lower }};
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	muts, err := LoadYamlMutationsWithVars(map[string]any{"schema": "ACME", "prefix": "acme"}, file)
+	if err != nil {
+		t.Fatalf("error loading mutations: %v", err)
+	}
+	mut, ok := muts.Latest("tenant").GetMutation("schema")
+	if !ok {
+		t.Fatal("mutation schema is missing")
+	}
+	if mut.Sql[0].Up != "create schema acme;" || mut.Sql[0].Down != "DROP schema acme;" {
+		t.Errorf("unexpected statement %#v", mut.Sql[0])
+	}
+	if len(mut.Roles) != 1 || mut.Roles[0] != "acme_reader" {
+		t.Errorf("unexpected roles %v", mut.Roles)
+	}
+
+	if _, err := LoadYamlMutations(file); err == nil {
+		t.Errorf("expected an error for the missing variables")
+	}
+
+	// variables that no statement uses do not change the hashes
+	other, err := LoadYamlMutationsWithVars(map[string]any{"schema": "acme", "prefix": "acme", "unused": 1}, file)
+	if err != nil {
+		t.Fatalf("error loading mutations: %v", err)
+	}
+	other_mut, _ := other.Latest("tenant").GetMutation("schema")
+	if other_mut.SqlHash() != mut.SqlHash() || other_mut.MetaHash() != mut.MetaHash() {
+		t.Errorf("hashes should only depend on the rendered statements")
+	}
+}
+
+// Collected mutations are rendered with the variables of the environment they are collected for, and read back
+// without any.
+func TestCollectRendered(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mutations.yml")
+	if err := os.WriteFile(file, []byte("schema:\n  sql:\n    - create schema {{ .Vars.schema }};\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadYamlMutations(file); err == nil || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("expected collecting without the variables to fail, got %v", err)
+	}
+
+	muts, err := LoadYamlMutationsWithVars(map[string]any{"schema": "acme"}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := muts.WriteYaml(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "{{") || !strings.Contains(buf.String(), "create schema acme;") {
+		t.Errorf("expected the collected statements to be rendered, got\n%s", buf.String())
+	}
+
+	collected := filepath.Join(t.TempDir(), "collected.yml")
+	if err := os.WriteFile(collected, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadYamlMutations(collected); err != nil {
+		t.Errorf("expected the collected file to be read without variables, got %v", err)
+	}
+}
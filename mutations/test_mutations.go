@@ -1,6 +1,7 @@
 package mutations
 
 import (
+	"context"
 	"slices"
 
 	au "github.com/logrusorgru/aurora"
@@ -8,7 +9,7 @@ import (
 
 // Test a mutation set by running all mutations independently, and resetting after each one.
 // Consider that the set is already up in the database.
-func TestMutationSet(runner Executor, set *MutationSet) (err error) {
+func TestMutationSet(ctx context.Context, runner Executor, set *MutationSet) (err error) {
 
 	runner.SetTesting()
 	defer func() {
@@ -18,7 +19,7 @@ func TestMutationSet(runner Executor, set *MutationSet) (err error) {
 		}
 	}()
 
-	if err = runner.SavePoint("test_mutation_set"); err != nil {
+	if err = runner.SavePoint(ctx, "test_mutation_set"); err != nil {
 		return err
 	}
 
@@ -28,32 +29,32 @@ func TestMutationSet(runner Executor, set *MutationSet) (err error) {
 	meta_down, _ := fake_empty_local_set.GetMutationsDelta(set, ITER_META)
 
 	// Down the meta to be able to test it independently
-	if err = meta_down.Run(runner); err != nil {
+	if err = meta_down.Run(ctx, runner); err != nil {
 		return err
 	}
 
 	// Test the meta
-	if err = MutationTestSequence(runner, set, ITER_META); err != nil {
+	if err = MutationTestSequence(ctx, runner, set, ITER_META); err != nil {
 		return err
 	}
 
 	runner.Logger().Println("Downing SQL", sql_down.Size())
 
 	// Then, down the SQL
-	if err = sql_down.Run(runner); err != nil {
+	if err = sql_down.Run(ctx, runner); err != nil {
 		return err
 	}
 
 	// Test the SQL
-	if err = MutationTestSequence(runner, set, ITER_SQL); err != nil {
+	if err = MutationTestSequence(ctx, runner, set, ITER_SQL); err != nil {
 		return err
 	}
 
-	if err = runner.RollbackToSavepoint("test_mutation_set"); err != nil {
+	if err = runner.RollbackToSavepoint(ctx, "test_mutation_set"); err != nil {
 		runner.Logger().Println(au.BrightRed("error rollbacking to savepoint"), err)
 	}
 
-	if err = runner.ReleaseSavepoint("test_mutation_set"); err != nil {
+	if err = runner.ReleaseSavepoint(ctx, "test_mutation_set"); err != nil {
 		runner.Logger().Println(au.BrightRed("error rollbacking to savepoint"), err)
 	}
 
@@ -62,9 +63,9 @@ func TestMutationSet(runner Executor, set *MutationSet) (err error) {
 }
 
 // With the test runner, try to up all mutations independently, and reset after each one.
-func MutationTestSequence(runner Executor, set *MutationSet, dir IterationDirection) error {
+func MutationTestSequence(ctx context.Context, runner Executor, set *MutationSet, dir IterationDirection) error {
 
-	if err := runner.SavePoint("independent_test"); err != nil {
+	if err := runner.SavePoint(ctx, "independent_test"); err != nil {
 		return err
 	}
 
@@ -78,7 +79,7 @@ func MutationTestSequence(runner Executor, set *MutationSet, dir IterationDirect
 		}
 
 		for _, mut := range inner_mutations {
-			if err := runner.Run(mut.Runnable(dir)); err != nil {
+			if err := runner.Run(ctx, mut.Runnable(dir)); err != nil {
 				return err
 			}
 		}
@@ -87,17 +88,17 @@ func MutationTestSequence(runner Executor, set *MutationSet, dir IterationDirect
 		down_dir.Down = true
 		slices.Reverse(inner_mutations)
 		for _, mut := range inner_mutations {
-			if err := runner.Run(mut.Runnable(down_dir)); err != nil {
+			if err := runner.Run(ctx, mut.Runnable(down_dir)); err != nil {
 				return err
 			}
 		}
 
-		if err := runner.RollbackToSavepoint("independent_test"); err != nil {
+		if err := runner.RollbackToSavepoint(ctx, "independent_test"); err != nil {
 			return err
 		}
 	}
 
-	if err := runner.ReleaseSavepoint("independent_test"); err != nil {
+	if err := runner.ReleaseSavepoint(ctx, "independent_test"); err != nil {
 		return err
 	}
 
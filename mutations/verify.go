@@ -0,0 +1,297 @@
+package mutations
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	au "github.com/logrusorgru/aurora"
+	"github.com/samber/oops"
+)
+
+// Verification of a database against the mutations recorded in it. The recorded mutations are replayed on an empty
+// scratch database, and the catalog is fingerprinted after every runnable to know which mutation made each object.
+// Objects are then compared with the ones of the verified database by their fingerprint.
+
+// CatalogEntry is an object of the catalog, such as "table api.users" or "column api.users.email".
+type CatalogEntry struct {
+	Key string
+	// The object it belongs to, its table or schema, so that extra objects can be tied to a mutation.
+	Parent      string
+	Fingerprint string
+}
+
+type CatalogFingerprint map[string]*CatalogEntry
+
+var sql_fingerprint = `with rels as (
+	select c.oid, c.relkind, c.relacl, c.relowner, c.relpersistence, c.relrowsecurity, n.nspname,
+		case c.relkind when 'v' then 'view' when 'm' then 'materialized view' when 'S' then 'sequence'
+			when 'f' then 'foreign table' when 'i' then 'index' when 'I' then 'index' else 'table' end as kind,
+		format('%I.%I', n.nspname, c.relname) as name
+	from pg_catalog.pg_class c
+	join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+	where c.relkind in ('r', 'p', 'v', 'm', 'S', 'f', 'i', 'I') and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_class", "c.oid") + `
+)
+select 'schema ' || quote_ident(n.nspname), '', ''
+from pg_catalog.pg_namespace n
+where ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_namespace", "n.oid") + `
+
+union all
+select 'extension ' || quote_ident(x.extname), '', ''
+from pg_catalog.pg_extension x
+
+union all
+select r.kind || ' ' || r.name,
+	coalesce((select t.kind || ' ' || t.name from rels t join pg_catalog.pg_index i on i.indrelid = t.oid where i.indexrelid = r.oid), 'schema ' || quote_ident(r.nspname)),
+	case
+	when r.relkind in ('v', 'm') then pg_catalog.pg_get_viewdef(r.oid)
+	when r.relkind in ('i', 'I') then pg_catalog.pg_get_indexdef(r.oid)
+	when r.relkind = 'S' then (select concat_ws(' ', format_type(s.seqtypid, null), s.seqincrement, s.seqmin, s.seqmax, s.seqstart, s.seqcycle) from pg_catalog.pg_sequence s where s.seqrelid = r.oid)
+	else concat_ws(' ', r.relpersistence, case when r.relrowsecurity then 'row level security' end)
+	end
+from rels r
+
+union all
+select format('column %s.%I', r.name, a.attname), r.kind || ' ' || r.name,
+	concat_ws(' ', format_type(a.atttypid, a.atttypmod), case when a.attnotnull then 'not null' end, pg_catalog.pg_get_expr(d.adbin, d.adrelid),
+		nullif(a.attidentity::text, ''), nullif(a.attgenerated::text, ''))
+from rels r
+join pg_catalog.pg_attribute a on a.attrelid = r.oid and a.attnum > 0 and not a.attisdropped
+left join pg_catalog.pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+where r.relkind in ('r', 'p', 'f')
+
+union all
+select format('constraint %I on %s', co.conname, r.name), r.kind || ' ' || r.name, pg_catalog.pg_get_constraintdef(co.oid)
+from rels r
+join pg_catalog.pg_constraint co on co.conrelid = r.oid
+
+union all
+select format('policy %I on %s', p.polname, r.name), r.kind || ' ' || r.name,
+	concat_ws(' ', p.polcmd, p.polpermissive,
+		(select string_agg(g, ',' order by g) from (select case when o = 0 then 'public' else quote_ident(pg_catalog.pg_get_userbyid(o)) end as g from unnest(p.polroles) o) s),
+		pg_catalog.pg_get_expr(p.polqual, p.polrelid), pg_catalog.pg_get_expr(p.polwithcheck, p.polrelid))
+from rels r
+join pg_catalog.pg_policy p on p.polrelid = r.oid
+
+union all
+select format('trigger %I on %s', tg.tgname, r.name), r.kind || ' ' || r.name, pg_catalog.pg_get_triggerdef(tg.oid)
+from rels r
+join pg_catalog.pg_trigger tg on tg.tgrelid = r.oid and not tg.tgisinternal
+
+union all
+select 'grants on ' || r.kind || ' ' || r.name, r.kind || ' ' || r.name, g.privileges
+from rels r, lateral (
+	select string_agg(p, ', ' order by p) as privileges
+	from (select ` + sql_grantee + ` || ' ' || a.privilege_type as p from aclexplode(r.relacl) a where a.grantee <> r.relowner) s
+) g
+where g.privileges is not null
+
+union all
+select 'grants on schema ' || quote_ident(n.nspname), 'schema ' || quote_ident(n.nspname), g.privileges
+from pg_catalog.pg_namespace n, lateral (
+	select string_agg(p, ', ' order by p) as privileges
+	from (select ` + sql_grantee + ` || ' ' || a.privilege_type as p from aclexplode(n.nspacl) a where a.grantee <> n.nspowner) s
+) g
+where g.privileges is not null and ` + sql_user_schema + `
+
+union all
+select format('type %I.%I', n.nspname, t.typname), 'schema ' || quote_ident(n.nspname),
+	case t.typtype
+	when 'e' then (select string_agg(e.enumlabel, ', ' order by e.enumsortorder) from pg_catalog.pg_enum e where e.enumtypid = t.oid)
+	when 'c' then (select string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod)), ', ' order by a.attnum)
+		from pg_catalog.pg_attribute a where a.attrelid = t.typrelid and a.attnum > 0 and not a.attisdropped)
+	when 'd' then concat_ws(' ', format_type(t.typbasetype, t.typtypmod), t.typdefault, case when t.typnotnull then 'not null' end,
+		(select string_agg(pg_catalog.pg_get_constraintdef(co.oid), ' ' order by co.conname) from pg_catalog.pg_constraint co where co.contypid = t.oid))
+	else ''
+	end
+from pg_catalog.pg_type t
+join pg_catalog.pg_namespace n on n.oid = t.typnamespace
+where t.typtype in ('e', 'c', 'd', 'r') and ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_type", "t.oid") + `
+	-- the row types of tables and views are not types of their own
+	and (t.typrelid = 0 or exists (select 1 from pg_catalog.pg_class c where c.oid = t.typrelid and c.relkind = 'c'))
+
+union all
+select format('%s %I.%I(%s)', case p.prokind when 'p' then 'procedure' when 'a' then 'aggregate' else 'function' end,
+		n.nspname, p.proname, pg_catalog.pg_get_function_identity_arguments(p.oid)),
+	'schema ' || quote_ident(n.nspname),
+	case when p.prokind = 'a' then '' else md5(pg_catalog.pg_get_functiondef(p.oid)) end
+from pg_catalog.pg_proc p
+join pg_catalog.pg_namespace n on n.oid = p.pronamespace
+where ` + sql_user_schema + ` and ` + sqlNotInExtension("pg_proc", "p.oid")
+
+// Fingerprint reads the objects of the catalog that dmut verifies, outside of its own schema.
+func (r *PgRunner) Fingerprint(ctx context.Context) (CatalogFingerprint, error) {
+	rows, err := r.conn.Query(ctx, sql_fingerprint, []string{})
+	if err != nil {
+		return nil, wrapPgError(err, sql_fingerprint)
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*CatalogEntry, error) {
+		var entry CatalogEntry
+		err := row.Scan(&entry.Key, &entry.Parent, &entry.Fingerprint)
+		return &entry, err
+	})
+	if err != nil {
+		return nil, wrapPgError(err, sql_fingerprint)
+	}
+
+	var res = make(CatalogFingerprint, len(entries))
+	for _, entry := range entries {
+		res[entry.Key] = entry
+	}
+	return res, nil
+}
+
+// ServerVersion returns the major version of the postgres server.
+func (r *PgRunner) ServerVersion(ctx context.Context) (int, error) {
+	var version int
+	sql := `SELECT current_setting('server_version_num')::int / 10000`
+	if err := r.conn.QueryRow(ctx, sql).Scan(&version); err != nil {
+		return 0, wrapPgError(err, sql)
+	}
+	return version, nil
+}
+
+// VerifyResult lists the objects of a mutation that the verified database does not have as the mutation made them.
+type VerifyResult struct {
+	Mutation *Mutation
+	Missing  []string
+	Changed  []string
+	// Objects of the database that belong to an object of the mutation, but that no mutation made.
+	Extra []string
+}
+
+func (v *VerifyResult) Print(w io.Writer) {
+	fmt.Fprintln(w, au.BrightRed("✗"), v.Mutation.DisplayName())
+	for _, key := range v.Missing {
+		fmt.Fprintln(w, "   ", au.BrightRed("missing"), key)
+	}
+	for _, key := range v.Changed {
+		fmt.Fprintln(w, "   ", au.BrightYellow("changed"), key)
+	}
+	for _, key := range v.Extra {
+		fmt.Fprintln(w, "   ", au.BrightBlue("extra"), key)
+	}
+}
+
+// Verify replays the mutations recorded in target on scratch, which has to be an empty database, and compares
+// the objects each one made with the ones of target. Pending mutations are left out.
+func Verify(ctx context.Context, target *PgRunner, scratch *PgRunner, namespaces *MutationNamespace) ([]*VerifyResult, error) {
+	if existing, err := scratch.GetDBNamespaces(ctx); err != nil {
+		return nil, err
+	} else if len(existing) > 0 {
+		return nil, oops.In("verify").With("namespaces", existing).Hint("give an empty database to replay the mutations on").Errorf("the scratch database already has mutations")
+	}
+
+	baseline, err := scratch.Fingerprint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mutation that last created or changed each object, and the object as it made it
+	var (
+		made    = make(map[string]*Mutation)
+		current = baseline
+	)
+	for _, namespace := range namespaces.Namespaces() {
+		if namespace == DMUT_NAMESPACE {
+			continue
+		}
+		set, err := namespaces.Latest(namespace).withoutPending()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := scratch.CreateMissingRoles(ctx, set.Roles()); err != nil {
+			return nil, err
+		}
+
+		var fake_empty_distant_set *MutationSet = nil
+		_, sql_up := set.GetMutationsDelta(fake_empty_distant_set, ITER_SQL)
+		_, meta_up := set.GetMutationsDelta(fake_empty_distant_set, ITER_META)
+
+		for _, runnable := range slices.Concat(sql_up.Values(), meta_up.Values()) {
+			if runnable.IsEmpty() {
+				continue
+			}
+			if err := scratch.Run(ctx, runnable); err != nil {
+				return nil, oops.Hint("the roles a mutation uses have to be declared in its roles").Wrap(err)
+			}
+
+			after, err := scratch.Fingerprint(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for key, entry := range after {
+				if before, ok := current[key]; !ok || before.Fingerprint != entry.Fingerprint {
+					made[key] = runnable.Mutation
+				}
+			}
+			for key := range current {
+				if _, ok := after[key]; !ok {
+					delete(made, key)
+				}
+			}
+			current = after
+		}
+	}
+
+	actual, err := target.Fingerprint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results = make(map[*Mutation]*VerifyResult)
+	var result = func(mut *Mutation) *VerifyResult {
+		if _, ok := results[mut]; !ok {
+			results[mut] = &VerifyResult{Mutation: mut}
+		}
+		return results[mut]
+	}
+
+	for key, mut := range made {
+		if entry, ok := actual[key]; !ok {
+			result(mut).Missing = append(result(mut).Missing, key)
+		} else if entry.Fingerprint != current[key].Fingerprint {
+			result(mut).Changed = append(result(mut).Changed, key)
+		}
+	}
+
+	for key, entry := range actual {
+		if _, ok := made[key]; ok {
+			continue
+		}
+		if _, ok := baseline[key]; ok {
+			continue
+		}
+		// the extra object belongs to the mutation that made its table or schema, objects elsewhere are not dmut's
+		for parent := entry.Parent; parent != ""; {
+			if mut, ok := made[parent]; ok {
+				result(mut).Extra = append(result(mut).Extra, key)
+				break
+			}
+			parent_entry, ok := actual[parent]
+			if !ok {
+				break
+			}
+			parent = parent_entry.Parent
+		}
+	}
+
+	var res []*VerifyResult
+	for _, v := range results {
+		slices.Sort(v.Missing)
+		slices.Sort(v.Changed)
+		slices.Sort(v.Extra)
+		res = append(res, v)
+	}
+	slices.SortFunc(res, func(a, b *VerifyResult) int {
+		if a.Mutation.Namespace != b.Mutation.Namespace {
+			return cmp.Compare(a.Mutation.Namespace, b.Mutation.Namespace)
+		}
+		return cmp.Compare(a.Mutation.Name, b.Mutation.Name)
+	})
+	return res, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/samber/oops"
+)
+
+// Template variables given on the command line, embedded in the commands that read yaml mutations.
+type VarsFlags struct {
+	Env      string   `short:"e" name:"env" help:"Load the template variables of dmut.<env>.yml, in the current directory."`
+	Var      []string `name:"var" sep:"none" help:"Template variable as key=value, available as {{ .Vars.key }}. Overrides the vars files."`
+	VarsFile string   `name:"vars" help:"Yaml file of template variables. Overrides the env file."`
+}
+
+func readVarsFile(res map[string]any, file string) error {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var vars map[string]any
+	if err := yaml.Unmarshal(contents, &vars); err != nil {
+		return oops.In("vars").With("file", file).Wrapf(err, "error reading variables")
+	}
+	maps.Copy(res, vars)
+	return nil
+}
+
+func (v VarsFlags) Vars() (map[string]any, error) {
+	var res = make(map[string]any)
+
+	if v.Env != "" {
+		if err := readVarsFile(res, "dmut."+v.Env+".yml"); err != nil {
+			return nil, oops.In("vars").With("env", v.Env).Wrap(err)
+		}
+	}
+
+	if v.VarsFile != "" {
+		if err := readVarsFile(res, v.VarsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range v.Var {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, oops.In("vars").With("var", kv).Errorf("expected key=value, got %s", kv)
+		}
+		res[key] = value
+	}
+
+	return res, nil
+}